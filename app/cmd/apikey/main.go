@@ -0,0 +1,134 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"strings"
+
+	"github.com/allokate-ai/events/app/internal/auth"
+	"github.com/allokate-ai/events/app/internal/config"
+	"github.com/allokate-ai/events/app/internal/db"
+
+	"github.com/google/uuid"
+)
+
+const usage = `usage: apikey create -name NAME [-source SOURCE] -scopes SCOPE[,SCOPE...]
+       apikey list
+       apikey revoke ID
+
+  create  create an API key and print it; it can't be shown again
+  list    list every API key
+  revoke  stop an API key from being accepted
+
+Scopes are publish, read, decrypt and admin. Publish and read may be limited
+to event names matching a topic pattern, as in publish:article.#.
+`
+
+// Creates, lists and revokes API keys, such as the first admin key, which the
+// /api/keys endpoints can't be used to create.
+func main() {
+	flags := flag.NewFlagSet("create", flag.ExitOnError)
+	name := flags.String("name", "", "name of the key, such as the service using it")
+	source := flags.String("source", "", "source the key publishes as")
+	scopes := flags.String("scopes", "", "comma separated scopes granted to the key")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flags.Usage = flag.Usage
+	flag.Parse()
+
+	if flag.NArg() == 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load the app's configuration settings.
+	config, err := config.Get()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Connect to the database.
+	pg, err := db.Connect(config.Database.Host, config.Database.Port, config.Database.User, config.Database.Password, config.Database.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pg.Close()
+
+	// Initialize the database.
+	if err := db.Init(pg); err != nil {
+		log.Fatal(err)
+	}
+
+	switch flag.Arg(0) {
+	case "create":
+		flags.Parse(flag.Args()[1:])
+
+		list := []string{}
+		for _, scope := range strings.Split(*scopes, ",") {
+			if scope = strings.TrimSpace(scope); scope == "" {
+				continue
+			}
+			if err := auth.ParseScope(scope); err != nil {
+				log.Fatal(err)
+			}
+			list = append(list, scope)
+		}
+		if *name == "" || len(list) == 0 {
+			flag.Usage()
+			os.Exit(2)
+		}
+
+		key := db.APIKey{Id: uuid.New().String(), Name: *name, Scopes: list}
+		if *source != "" {
+			key.Source = source
+		}
+
+		secret, prefix, err := auth.GenerateKey()
+		if err != nil {
+			log.Fatal(err)
+		}
+		key.Prefix = prefix
+
+		if key, err = db.CreateAPIKey(pg, key, auth.Hash(secret)); err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("created %s (%s)\n%s\n", key.Name, key.Id, secret)
+
+	case "list":
+		list, err := db.ListAPIKeys(pg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, key := range list {
+			source := "-"
+			if key.Source != nil {
+				source = *key.Source
+			}
+			status := "active"
+			if key.RevokedAt != nil {
+				status = "revoked " + key.RevokedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%s\t%s…\t%s\t%s\t%s\t%s\n", key.Id, key.Prefix, key.Name, source, strings.Join(key.Scopes, ","), status)
+		}
+
+	case "revoke":
+		if flag.NArg() != 2 {
+			flag.Usage()
+			os.Exit(2)
+		}
+		err := db.RevokeAPIKey(pg, flag.Arg(1))
+		if errors.Is(err, db.ErrNoSuchAPIKey) {
+			log.Fatalf("%s: %s", flag.Arg(1), err)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("revoked %s\n", flag.Arg(1))
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
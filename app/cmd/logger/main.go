@@ -1,12 +1,15 @@
 package main
 
 import (
+	"context"
 	"fmt"
 	"log"
 
 	"github.com/allokate-ai/events/app/internal/config"
 	"github.com/allokate-ai/events/app/internal/db"
 	"github.com/allokate-ai/events/app/internal/queue"
+	"github.com/allokate-ai/events/app/internal/retention"
+	"github.com/allokate-ai/events/app/internal/rollup"
 	events "github.com/allokate-ai/events/app/pkg/client"
 )
 
@@ -29,6 +32,12 @@ func main() {
 		log.Fatal(err)
 	}
 
+	// Keep partitions ready and drop events past their retention period.
+	go retention.Enforce(context.Background(), pg, config.Retention.Interval)
+
+	// Keep the hourly event counts up to date.
+	go rollup.Refresh(context.Background(), pg, config.Rollup.Interval, config.Rollup.Lookback)
+
 	amqp, err := queue.Connect(config.AMQPConfig.Host, config.AMQPConfig.Port, config.AMQPConfig.Username, config.AMQPConfig.Password)
 	if err != nil {
 		log.Fatal(err)
@@ -43,14 +52,23 @@ func main() {
 		log.Fatal(err)
 	}
 
-	// Run an endless consumer loop.
-	if err := queue.Consume(ch, func(event *events.GenericEvent) bool {
-		fmt.Println("Received event:", event)
-		if _, err := db.UpsertEvent(pg, *event); err != nil {
+	// Run an endless consumer loop, storing events in batches. Events that
+	// can't be stored are retried after a growing delay and eventually dead
+	// lettered.
+	opts := queue.ConsumeOptions{
+		Queue:        "log",
+		MaxAttempts:  config.Consumer.MaxAttempts,
+		RetryDelays:  config.Consumer.RetryDelays,
+		BatchSize:    config.Consumer.BatchSize,
+		BatchTimeout: config.Consumer.BatchTimeout,
+	}
+	if err := queue.ConsumeBatch(ch, opts, func(list []events.GenericEvent) error {
+		fmt.Println("Received events:", len(list))
+		if err := db.UpsertEvents(pg, list); err != nil {
 			log.Println(err)
-			return false
+			return err
 		}
-		return true
+		return nil
 	}); err != nil {
 		log.Fatal(err)
 	}
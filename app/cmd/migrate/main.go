@@ -0,0 +1,90 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"log"
+	"os"
+
+	"github.com/allokate-ai/events/app/internal/config"
+	"github.com/allokate-ai/events/app/internal/db"
+)
+
+const usage = `usage: migrate [-steps N] up|down|status
+
+  up      apply pending migrations, all of them unless -steps is given
+  down    revert the latest migration, or the latest N with -steps
+  status  list every migration and when it was applied
+`
+
+// Applies, reverts and lists the database migrations. The server and logger
+// apply pending migrations themselves when they start.
+func main() {
+	steps := flag.Int("steps", 0, "number of migrations to apply or revert")
+	flag.Usage = func() { fmt.Fprint(os.Stderr, usage) }
+	flag.Parse()
+
+	if flag.NArg() != 1 || *steps < 0 {
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	// Load the app's configuration settings.
+	config, err := config.Get()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Connect to the database.
+	pg, err := db.Connect(config.Database.Host, config.Database.Port, config.Database.User, config.Database.Password, config.Database.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pg.Close()
+
+	switch flag.Arg(0) {
+	case "up":
+		done, err := db.MigrateUp(pg, *steps)
+		for _, m := range done {
+			fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(done) == 0 {
+			fmt.Println("nothing to apply")
+		}
+
+	case "down":
+		if *steps == 0 {
+			*steps = 1
+		}
+		done, err := db.MigrateDown(pg, *steps)
+		for _, m := range done {
+			fmt.Printf("reverted %04d_%s\n", m.Version, m.Name)
+		}
+		if err != nil {
+			log.Fatal(err)
+		}
+		if len(done) == 0 {
+			fmt.Println("nothing to revert")
+		}
+
+	case "status":
+		list, err := db.MigrationStatuses(pg)
+		if err != nil {
+			log.Fatal(err)
+		}
+		for _, m := range list {
+			applied := "pending"
+			if m.AppliedAt != nil {
+				applied = "applied " + m.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%04d_%s\t%s\n", m.Version, m.Name, applied)
+		}
+
+	default:
+		flag.Usage()
+		os.Exit(2)
+	}
+}
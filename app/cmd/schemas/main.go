@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"sort"
+
+	"github.com/allokate-ai/events/app/internal/config"
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/schema"
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// Seeds the schema registry with the schemas of the events defined in the
+// client package. Events that already have a schema are left alone unless
+// -force is given, in which case a new version is registered.
+func main() {
+	force := flag.Bool("force", false, "register a new version even if a schema already exists")
+	flag.Parse()
+
+	// Load the app's configuration settings.
+	config, err := config.Get()
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	// Connect to the database.
+	pg, err := db.Connect(config.Database.Host, config.Database.Port, config.Database.User, config.Database.Password, config.Database.Database)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer pg.Close()
+
+	// Initialize the database.
+	if err := db.Init(pg); err != nil {
+		log.Fatal(err)
+	}
+
+	schemas := events.Schemas()
+
+	names := []string{}
+	for name := range schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if !*force {
+			_, err := db.GetSchema(pg, name, 0)
+			if err == nil {
+				fmt.Printf("%s: already registered, skipping\n", name)
+				continue
+			}
+			if !errors.Is(err, db.ErrNoSuchSchema) {
+				log.Fatal(err)
+			}
+		}
+
+		data, err := json.Marshal(schemas[name])
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		if _, err := schema.Compile(name, data); err != nil {
+			log.Fatalf("%s: %s", name, err)
+		}
+
+		s, err := db.CreateSchema(pg, name, data)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Printf("%s: registered version %d\n", name, s.Version)
+	}
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/allokate-ai/events/app/internal/auth"
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Upper bound on the length of the name and source of an API key.
+const maxAPIKeyNameLength = 255
+
+// apiKeyRoutes registers the endpoints for managing API keys.
+func apiKeyRoutes(router *gin.Engine, pg *sql.DB, authenticator *auth.Authenticator) {
+	// Endpoint for fetching every API key. Only the prefix of each key is
+	// shown.
+	router.GET("/api/keys", func(c *gin.Context) {
+		list, err := db.ListAPIKeys(pg)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	// Endpoint for creating an API key. The key itself is only ever returned
+	// in the response to this request.
+	router.POST("/api/keys", func(c *gin.Context) {
+		// Read request body.
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": "failed to read request body",
+			})
+			return
+		}
+
+		// Decode request body.
+		var body struct {
+			Name   string   `json:"name"`
+			Source *string  `json:"source"`
+			Scopes []string `json:"scopes"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": "failed to parse json",
+			})
+			return
+		}
+
+		// Validate the request.
+		errors := apiKeyErrors(body.Name, body.Source, body.Scopes)
+
+		// Return any errors if appropriate.
+		if len(errors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": errors,
+			})
+			return
+		}
+
+		secret, prefix, err := auth.GenerateKey()
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"failed to generate key"}})
+			log.Println(err)
+			return
+		}
+
+		key, err := db.CreateAPIKey(pg, db.APIKey{
+			Id:     uuid.New().String(),
+			Name:   body.Name,
+			Prefix: prefix,
+			Source: body.Source,
+			Scopes: body.Scopes,
+		}, auth.Hash(secret))
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, struct {
+			db.APIKey
+			Key string `json:"key"`
+		}{key, secret})
+	})
+
+	// Endpoint for revoking an API key.
+	router.DELETE("/api/keys/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		if !validation.IsValidUUID(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"id must be a valid UUID4 string"}})
+			return
+		}
+
+		err := db.RevokeAPIKey(pg, id)
+		if errors.Is(err, db.ErrNoSuchAPIKey) {
+			c.JSON(http.StatusNotFound, gin.H{"errors": []string{err.Error()}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		authenticator.Forget(id)
+		c.Status(http.StatusNoContent)
+	})
+}
+
+// apiKeyErrors validates the settings of a new API key.
+func apiKeyErrors(name string, source *string, scopes []string) []string {
+	errors := []string{}
+
+	if name == "" || len(name) > maxAPIKeyNameLength {
+		errors = append(errors, fmt.Sprintf("name must be between 1 and %d characters", maxAPIKeyNameLength))
+	}
+
+	if source != nil && (*source == "" || len(*source) > maxAPIKeyNameLength) {
+		errors = append(errors, fmt.Sprintf("source must be between 1 and %d characters", maxAPIKeyNameLength))
+	}
+
+	if len(scopes) == 0 {
+		errors = append(errors, "at least one scope is required")
+	}
+	for _, scope := range scopes {
+		if err := auth.ParseScope(scope); err != nil {
+			errors = append(errors, err.Error())
+		}
+	}
+	if source == nil && auth.Has(scopes, auth.ScopePublish) && !auth.Has(scopes, auth.ScopeAdmin) {
+		errors = append(errors, "keys that publish must have a source")
+	}
+
+	return errors
+}
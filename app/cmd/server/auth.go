@@ -0,0 +1,177 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/allokate-ai/events/app/internal/auth"
+	"github.com/allokate-ai/events/app/internal/db"
+	events "github.com/allokate-ai/events/app/pkg/client"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+)
+
+// Key under which the principal of a request is kept in its context.
+const principalContextKey = "principal"
+
+// Scopes required by the routes that don't need the admin scope, by method
+// and path.
+var routeScopes = map[string]string{
+	"PUT /api/events":                 auth.ScopePublish,
+	"POST /api/events:action":         auth.ScopePublish,
+	"GET /api/events":                 auth.ScopeRead,
+	"GET /api/events/names":           auth.ScopeRead,
+	"GET /api/events/sources":         auth.ScopeRead,
+	"GET /api/events/search":          auth.ScopeRead,
+	"GET /api/events/stats":           auth.ScopeRead,
+	"GET /api/events/stream":          auth.ScopeRead,
+	"GET /api/events/ws":              auth.ScopeRead,
+	"GET /api/events/:id":             auth.ScopeRead,
+	"GET /api/schemas":                auth.ScopeRead,
+	"GET /api/schemas/:name":          auth.ScopeRead,
+	"GET /api/schemas/:name/:version": auth.ScopeRead,
+	"GET /api/quotas":                 auth.ScopePublish,
+}
+
+// Routes that browsers reach through EventSource and WebSocket, which can't set
+// the Authorization header.
+var browserRoutes = map[string]bool{
+	"/api/events/stream": true,
+	"/api/events/ws":     true,
+}
+
+// Subprotocol that a websocket client lists just before its token, as in
+// new WebSocket(url, ["bearer", token]).
+const bearerProtocol = "bearer"
+
+// streamCredentials moves the token of a stream or websocket request without
+// an Authorization header into one, taking it from the access_token query
+// parameter or from the subprotocols. It runs before the request is logged so
+// that the token is left out of the log.
+func streamCredentials() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !browserRoutes[c.FullPath()] || c.GetHeader("Authorization") != "" {
+			return
+		}
+
+		query := c.Request.URL.Query()
+		if token := query.Get("access_token"); token != "" {
+			query.Del("access_token")
+			c.Request.URL.RawQuery = query.Encode()
+			c.Request.Header.Set("Authorization", "Bearer "+token)
+			return
+		}
+
+		protocols := websocket.Subprotocols(c.Request)
+		for i, protocol := range protocols {
+			if protocol == bearerProtocol && i+1 < len(protocols) {
+				c.Request.Header.Set("Authorization", "Bearer "+protocols[i+1])
+				return
+			}
+		}
+	}
+}
+
+// authenticate requires every request to carry a bearer token in the
+// Authorization header granting the scope its route needs. The token is either
+// an API key or, if a verifier is given, a token from the identity provider.
+func authenticate(authenticator *auth.Authenticator, verifier *auth.TokenVerifier) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		// Unknown routes get their 404 as usual.
+		if c.FullPath() == "" {
+			return
+		}
+
+		secret := strings.TrimPrefix(c.GetHeader("Authorization"), "Bearer ")
+		if secret == "" || secret == c.GetHeader("Authorization") {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"errors": []string{"an api key or token is required"}})
+			return
+		}
+
+		var p auth.Principal
+		var err error
+		if verifier != nil && !auth.IsKey(secret) {
+			p, err = verifier.Verify(secret)
+		} else {
+			var key db.APIKey
+			key, err = authenticator.Authenticate(secret)
+			p = auth.KeyPrincipal(key)
+		}
+		if errors.Is(err, auth.ErrInvalidKey) || errors.Is(err, auth.ErrInvalidToken) {
+			c.Header("WWW-Authenticate", "Bearer")
+			c.AbortWithStatusJSON(http.StatusUnauthorized, gin.H{"errors": []string{err.Error()}})
+			return
+		}
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		scope, ok := routeScopes[c.Request.Method+" "+c.FullPath()]
+		if !ok {
+			scope = auth.ScopeAdmin
+		}
+		if !p.Has(scope) {
+			c.AbortWithStatusJSON(http.StatusForbidden, gin.H{"errors": []string{fmt.Sprintf("%s lacks the %s scope", p.Name, scope)}})
+			return
+		}
+
+		c.Set(principalContextKey, p)
+	}
+}
+
+// principal returns who the request was made by, if anyone. There is no one
+// when authentication is disabled.
+func principal(c *gin.Context) (auth.Principal, bool) {
+	val, ok := c.Get(principalContextKey)
+	if !ok {
+		return auth.Principal{}, false
+	}
+	p, ok := val.(auth.Principal)
+	return p, ok
+}
+
+// publishError explains why the request may not publish the event, or returns
+// an empty string if it may. Keys only publish as their own source.
+func publishError(c *gin.Context, event events.GenericEvent) string {
+	p, ok := principal(c)
+	if !ok {
+		return ""
+	}
+
+	if p.Source == nil {
+		return fmt.Sprintf("%s has no source to publish as", p.Name)
+	}
+	if event.Source != *p.Source {
+		return fmt.Sprintf("%s may only publish as source %s", p.Name, *p.Source)
+	}
+	if !p.Allows(auth.ScopePublish, event.Name) {
+		return fmt.Sprintf("%s may not publish %s events", p.Name, event.Name)
+	}
+
+	return ""
+}
+
+// restrictReads narrows the filter down to the events the request may read.
+func restrictReads(c *gin.Context, filter *db.EventFilter) {
+	p, ok := principal(c)
+	if !ok {
+		return
+	}
+	if patterns, all := auth.Patterns(p.Scopes, auth.ScopeRead); !all {
+		filter.NamePatterns = patterns
+	}
+	filter.ExcludedNamePatterns = p.Hidden
+}
+
+// canRead reports whether the request may read events with the given name.
+func canRead(c *gin.Context, name string) bool {
+	p, ok := principal(c)
+	return !ok || p.Allows(auth.ScopeRead, name)
+}
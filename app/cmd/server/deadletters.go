@@ -0,0 +1,121 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/allokate-ai/events/app/internal/queue"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Number of dead letters looked at by default, and at most, per request.
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+// deadLetterRoutes registers the endpoints for inspecting and re-driving
+// messages that consumers gave up on.
+func deadLetterRoutes(router *gin.Engine, publisher *queue.Publisher) {
+	// Endpoint for inspecting the messages at the front of the dead letter
+	// queue. Messages are left in the queue.
+	router.GET("/api/dead-letters", func(c *gin.Context) {
+		limit := defaultDeadLetterLimit
+		if v := c.Query("limit"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 1 || n > maxDeadLetterLimit {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"errors": []string{fmt.Sprintf("limit must be a number between 1 and %d", maxDeadLetterLimit)},
+				})
+				return
+			}
+			limit = n
+		}
+
+		ch, err := publisher.Channel()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"errors": []string{"event queue unavailable"}})
+			log.Println(err)
+			return
+		}
+		// Closing the channel puts the messages back on the queue.
+		defer ch.Close()
+
+		list, err := queue.PeekDeadLetters(ch, limit)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"errors": []string{"event queue unavailable"}})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, list)
+	})
+
+	// Endpoint for sending dead letters back to the queue they came from,
+	// either those with the given IDs or every one of them.
+	router.POST("/api/dead-letters/redrive", func(c *gin.Context) {
+		// Read request body.
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": "failed to read request body",
+			})
+			return
+		}
+
+		// Decode the optional request body.
+		var body struct {
+			Ids   []string `json:"ids"`
+			Limit int      `json:"limit"`
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &body); err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{
+					"errors": "failed to parse json",
+				})
+				return
+			}
+		}
+
+		if body.Limit == 0 {
+			body.Limit = maxDeadLetterLimit
+		}
+		if body.Limit < 0 || body.Limit > maxDeadLetterLimit {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": []string{fmt.Sprintf("limit must be a number between 1 and %d", maxDeadLetterLimit)},
+			})
+			return
+		}
+
+		ch, err := publisher.Channel()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"errors": []string{"event queue unavailable"}})
+			log.Println(err)
+			return
+		}
+		// Closing the channel puts the messages that were passed over back.
+		defer ch.Close()
+
+		list, err := queue.RedriveDeadLetters(ch, body.Ids, body.Limit, func(m queue.Message) error {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), publishTimeout)
+			defer cancel()
+			return publisher.PublishAll(ctx, []queue.Message{m})
+		})
+		if err != nil {
+			log.Println(err)
+			c.JSON(http.StatusServiceUnavailable, gin.H{
+				"errors":   []string{"event queue unavailable"},
+				"redriven": list,
+			})
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"redriven": list})
+	})
+}
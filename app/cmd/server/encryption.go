@@ -0,0 +1,134 @@
+package main
+
+import (
+	"crypto/subtle"
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/allokate-ai/events/app/internal/auth"
+	"github.com/allokate-ai/events/app/internal/config"
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/encryption"
+	events "github.com/allokate-ai/events/app/pkg/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Number of events rewrapped by POST /api/encryption/rewrap when no limit
+	// is given.
+	defaultRewrapSize = 1000
+
+	// Upper bound on the limit accepted by POST /api/encryption/rewrap.
+	maxRewrapSize = 10000
+)
+
+// newEncrypter sets up the encryption of personal fields, unless no key file
+// is configured. Fields default to the personal fields of the events
+// published by the client package.
+func newEncrypter(config config.EncryptionConfig) (*encryption.Encrypter, error) {
+	if config.KeyFile == "" {
+		return nil, nil
+	}
+
+	keys, err := encryption.LoadKeyFile(config.KeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := events.PersonalFields
+	if config.FieldsFile != "" {
+		if fields, err = encryption.LoadFields(config.FieldsFile); err != nil {
+			return nil, err
+		}
+	}
+
+	return encryption.NewEncrypter(keys, fields), nil
+}
+
+// decrypter opens the encrypted fields of events for readers whose API key has
+// the decrypt scope, or who present the decrypt token in the X-Decrypt-Token
+// header. Everyone else gets the events as they are stored.
+type decrypter struct {
+	encrypter *encryption.Encrypter
+	token     string
+}
+
+func (d decrypter) allowed(c *gin.Context) bool {
+	if d.encrypter == nil {
+		return false
+	}
+	if p, ok := principal(c); ok && p.Has(auth.ScopeDecrypt) {
+		return true
+	}
+	if d.token == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(c.GetHeader("X-Decrypt-Token")), []byte(d.token)) == 1
+}
+
+// event returns the event decrypted, if the reader is allowed to see it so.
+// Events that can't be decrypted, such as those whose master key has been
+// retired, are returned as they are.
+func (d decrypter) event(c *gin.Context, event events.GenericEvent) events.GenericEvent {
+	if !d.allowed(c) {
+		return event
+	}
+
+	decrypted, err := d.encrypter.Decrypt(event)
+	if err != nil {
+		log.Println(fmt.Errorf("decrypting event %s: %w", event.Id, err))
+		return event
+	}
+	return decrypted
+}
+
+// events decrypts each of the events in place.
+func (d decrypter) events(c *gin.Context, list []events.GenericEvent) []events.GenericEvent {
+	if !d.allowed(c) {
+		return list
+	}
+	for i, event := range list {
+		list[i] = d.event(c, event)
+	}
+	return list
+}
+
+// encryptionRoutes registers the endpoints for managing the encryption of
+// personal fields.
+func encryptionRoutes(router *gin.Engine, pg *sql.DB, encrypter *encryption.Encrypter) {
+	// Endpoint for rewrapping the data keys of stored events with the current
+	// master key after a rotation. It works through a batch at a time and is
+	// called again with the returned cursor until nothing remains.
+	router.POST("/api/encryption/rewrap", func(c *gin.Context) {
+		if encrypter == nil {
+			c.JSON(http.StatusNotFound, gin.H{"errors": []string{"encryption is not enabled"}})
+			return
+		}
+
+		limit := defaultRewrapSize
+		if val, ok := c.GetQuery("limit"); ok {
+			v, err := strconv.Atoi(val)
+			if err != nil || v < 1 || v > maxRewrapSize {
+				c.JSON(http.StatusBadRequest, gin.H{"errors": []string{fmt.Sprintf("limit must be an integer between 1 and %d", maxRewrapSize)}})
+				return
+			}
+			limit = v
+		}
+
+		result, err := db.RewrapEvents(pg, encrypter.CurrentKeyId(), c.Query("cursor"), limit, encrypter.Rewrap)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		for _, failure := range result.Failed {
+			log.Printf("failed to rewrap event %s: %s", failure.Id, failure.Error)
+		}
+
+		c.JSON(http.StatusOK, result)
+	})
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/encryption"
+	"github.com/allokate-ai/events/app/internal/erasure"
+	"github.com/allokate-ai/events/app/internal/queue"
+	events "github.com/allokate-ai/events/app/pkg/client"
+	"github.com/allokate-ai/events/app/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+const (
+	// Number of erasures returned by GET /api/erasures.
+	erasureListSize = 50
+
+	// Upper bounds on the length of an erasure's subject and reason.
+	maxSubjectLength = 255
+	maxReasonLength  = 1000
+)
+
+// erasureRoutes registers the endpoints for deleting and redacting stored
+// events.
+func erasureRoutes(router *gin.Engine, pg *sql.DB, publisher *queue.Publisher, encrypter *encryption.Encrypter, useOutbox bool) {
+	// Endpoint for deleting an event. A tombstone is published so that
+	// downstream copies can be purged too.
+	router.DELETE("/api/events/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		if !validation.IsValidUUID(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"id must be a valid UUID4 string"}})
+			return
+		}
+
+		reason := c.Query("reason")
+		if len(reason) > maxReasonLength {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{fmt.Sprintf("reason must be at most %d characters", maxReasonLength)}})
+			return
+		}
+
+		record, list, err := db.DeleteEvent(pg, db.Erasure{
+			Id:     uuid.New().String(),
+			Mode:   db.ErasureDelete,
+			Reason: reason,
+		}, id)
+		if errors.Is(err, db.ErrNoSuchEvent) {
+			c.JSON(http.StatusNotFound, gin.H{"errors": []string{err.Error()}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		if !publishTombstones(c, pg, publisher, useOutbox, record, list) {
+			return
+		}
+
+		c.JSON(http.StatusOK, record)
+	})
+
+	// Endpoint for erasing every event mentioning a subject, such as an email
+	// address or user ID, by deleting the events or redacting their personal
+	// data.
+	router.POST("/api/erasures", func(c *gin.Context) {
+		// Read request body.
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": "failed to read request body",
+			})
+			return
+		}
+
+		// Decode request body.
+		var body struct {
+			Subject string `json:"subject"`
+			Mode    string `json:"mode"`
+			Reason  string `json:"reason"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": "failed to parse json",
+			})
+			return
+		}
+
+		// Validate the request.
+		errors := []string{}
+
+		subject := strings.TrimSpace(body.Subject)
+		if subject == "" || len(subject) > maxSubjectLength {
+			errors = append(errors, fmt.Sprintf("subject must be between 1 and %d characters", maxSubjectLength))
+		}
+
+		if body.Mode == "" {
+			body.Mode = db.ErasureRedact
+		}
+		if body.Mode != db.ErasureDelete && body.Mode != db.ErasureRedact {
+			errors = append(errors, "mode must be either delete or redact")
+		}
+
+		if len(body.Reason) > maxReasonLength {
+			errors = append(errors, fmt.Sprintf("reason must be at most %d characters", maxReasonLength))
+		}
+
+		// Return any errors if appropriate.
+		if len(errors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": errors,
+			})
+			return
+		}
+
+		hash := erasure.HashSubject(subject)
+		record, list, err := db.EraseSubject(pg, db.Erasure{
+			Id:          uuid.New().String(),
+			Mode:        body.Mode,
+			SubjectHash: &hash,
+			Reason:      body.Reason,
+		}, subject, encrypter.BlindIndex(subject), func(event events.GenericEvent) (json.RawMessage, error) {
+			return erasure.Redact(event, subject)
+		})
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		if !publishTombstones(c, pg, publisher, useOutbox, record, list) {
+			return
+		}
+
+		c.JSON(http.StatusOK, record)
+	})
+
+	// Endpoint for fetching the audit trail of the most recent erasures.
+	router.GET("/api/erasures", func(c *gin.Context) {
+		list, err := db.ListErasures(pg, erasureListSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	// Endpoint for fetching an erasure.
+	router.GET("/api/erasures/:id", func(c *gin.Context) {
+		id := c.Param("id")
+		if !validation.IsValidUUID(id) {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"id must be a valid UUID4 string"}})
+			return
+		}
+
+		record, err := db.GetErasure(pg, id)
+		if errors.Is(err, db.ErrNoSuchErasure) {
+			c.JSON(http.StatusNotFound, gin.H{"errors": []string{err.Error()}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		c.JSON(http.StatusOK, record)
+	})
+}
+
+// publishTombstones publishes a tombstone for each event affected by an
+// erasure. The erasure has already happened by then, so a failure is reported
+// along with the erasure record.
+func publishTombstones(c *gin.Context, pg *sql.DB, publisher *queue.Publisher, useOutbox bool, record db.Erasure, list []events.GenericEvent) bool {
+	if len(list) == 0 {
+		return true
+	}
+
+	action := events.TombstoneDeleted
+	if record.Mode == db.ErasureRedact {
+		action = events.TombstoneRedacted
+	}
+
+	tombstones, err := erasure.Tombstones(list, action, record.Id)
+	if err == nil {
+		if useOutbox {
+			err = db.InsertEventsWithOutbox(pg, tombstones)
+		} else {
+			ctx, cancel := context.WithTimeout(c.Request.Context(), publishTimeout)
+			defer cancel()
+			err = publisher.EnqueueAll(ctx, tombstones)
+		}
+	}
+	if err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{
+			"errors":  []string{"events were erased but their tombstones could not be published"},
+			"erasure": record,
+		})
+		log.Println(err)
+		return false
+	}
+
+	return true
+}
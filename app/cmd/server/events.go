@@ -0,0 +1,241 @@
+package main
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/queue"
+	"github.com/allokate-ai/events/app/internal/schema"
+	events "github.com/allokate-ai/events/app/pkg/client"
+	"github.com/allokate-ai/events/app/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+// parseEvent builds an event from a decoded request body. Problems with the
+// body are returned as a list of human readable errors; the returned error is
+// only set if validation itself failed.
+func parseEvent(body map[string]any, registry *schema.Registry) (events.GenericEvent, []string, error) {
+	event := events.GenericEvent{}
+	errors := []string{}
+
+	// Validate optional id field.
+	if val, ok := body["id"]; ok && val != nil {
+		if s, ok := val.(string); !ok || !validation.IsValidUUID(s) {
+			errors = append(errors, "id must be a valid UUID4 string")
+		} else {
+			event.Id = s
+		}
+	}
+
+	// Validate optional timestamp field.
+	if val, ok := body["timestamp"]; ok {
+		if s, ok := val.(string); !ok {
+			errors = append(errors, "timestamp must be a string")
+		} else {
+			if v, err := time.Parse(time.RFC3339, s); err != nil {
+				errors = append(errors, "timestamp must be an RFC-3339 compliant string")
+			} else {
+				event.Timestamp = v
+			}
+		}
+	} else {
+		event.Timestamp = time.Now()
+	}
+
+	// Validate required name field.
+	if val, ok := body["name"]; ok {
+		if s, ok := val.(string); !ok {
+			errors = append(errors, "name must be a string")
+		} else {
+			event.Name = strings.ReplaceAll(strings.ReplaceAll(s, " ", "-"), " ", "-")
+		}
+	} else {
+		errors = append(errors, "name is required")
+	}
+
+	// Validate required source field.
+	if val, ok := body["source"]; ok {
+		if s, ok := val.(string); !ok {
+			errors = append(errors, "source must be a string")
+		} else {
+			event.Source = strings.ReplaceAll(strings.ReplaceAll(s, " ", "-"), " ", "-")
+		}
+	} else {
+		errors = append(errors, "source is required")
+	}
+
+	// Validate optional body field.
+	if val, ok := body["body"]; ok {
+		switch v := val.(type) {
+		case string:
+			if validation.IsJSON(v) {
+				event.Body = []byte(v)
+			} else {
+				errors = append(errors, "body must be valid JSON object")
+			}
+		case []byte:
+			if validation.IsJSON(v) {
+				event.Body = v
+			} else {
+				errors = append(errors, "body must be valid JSON object")
+			}
+		case map[string]any:
+			b, err := json.Marshal(&v)
+			if err != nil {
+				errors = append(errors, "body must be valid JSON object")
+			} else {
+				event.Body = b
+			}
+		case nil:
+			event.Body = nil
+		default:
+			errors = append(errors, "body must be valid JSON object")
+		}
+
+	} else {
+		event.Body = nil
+	}
+
+	// Validate the body against the schema registered for the event.
+	if event.Name != "" {
+		violations, err := registry.Validate(event.Name, event.Body)
+		if err != nil {
+			return event, errors, err
+		}
+		errors = append(errors, violations...)
+	}
+
+	return event, errors, nil
+}
+
+// parseEventFilter reads the from, to, name, source and body filters shared
+// by the endpoints listing events out of the query string. Events the API key
+// may not read are always left out.
+func parseEventFilter(c *gin.Context) (db.EventFilter, []string) {
+	filter := db.EventFilter{}
+	errors := []string{}
+
+	// Validate optional from field.
+	if val, ok := c.GetQuery("from"); ok {
+		if v, err := time.Parse(time.RFC3339, val); err != nil {
+			errors = append(errors, "from must be an RFC-3339 compliant string")
+		} else {
+			filter.From = &v
+		}
+	}
+
+	// Validate optional to field.
+	if val, ok := c.GetQuery("to"); ok {
+		if v, err := time.Parse(time.RFC3339, val); err != nil {
+			errors = append(errors, "to must be an RFC-3339 compliant string")
+		} else {
+			filter.To = &v
+		}
+	}
+
+	if v, ok := c.GetQuery("name"); ok {
+		filter.Name = &v
+	}
+
+	if v, ok := c.GetQuery("source"); ok {
+		filter.Source = &v
+	}
+
+	// Validate optional body filters.
+	body, bodyErrors := parseBodyFilters(c.Request.URL.RawQuery)
+	filter.Body = body
+	errors = append(errors, bodyErrors...)
+
+	restrictReads(c, &filter)
+
+	return filter, errors
+}
+
+// parseBodyFilters picks the body filters, such as body.ticker=AAPL or
+// body.dividendRate>0.5, out of a raw query string. The parts are unescaped
+// whole since the operator may itself contain an equals sign.
+func parseBodyFilters(rawQuery string) ([]db.BodyFilter, []string) {
+	filters := []db.BodyFilter{}
+	errors := []string{}
+
+	for _, part := range strings.Split(rawQuery, "&") {
+		expr, err := url.QueryUnescape(part)
+		if err != nil || !strings.HasPrefix(expr, "body.") {
+			continue
+		}
+
+		filter, err := db.ParseBodyFilter(expr)
+		if err != nil {
+			errors = append(errors, err.Error())
+			continue
+		}
+		filters = append(filters, filter)
+	}
+
+	if len(filters) > maxBodyFilters {
+		errors = append(errors, fmt.Sprintf("at most %d body filters may be given", maxBodyFilters))
+	}
+
+	return filters, errors
+}
+
+// idempotencyKey returns the key used to deduplicate the event: the ID
+// supplied by the client if there is one, so that an event is only queued once
+// however it is sent, else the value of the Idempotency-Key header, if any.
+// Keys are scoped to the event's source so that producers can't collide with
+// one another.
+func idempotencyKey(header string, event events.GenericEvent) string {
+	if event.Id != "" {
+		return event.Source + ":id:" + event.Id
+	}
+	if header != "" {
+		return event.Source + ":key:" + header
+	}
+	return ""
+}
+
+// requestHash fingerprints a decoded request body so that a key reused for a
+// different request can be told apart from a retry. Object keys are sorted
+// when encoding, so the layout of the original JSON doesn't matter.
+func requestHash(body map[string]any) string {
+	data, _ := json.Marshal(body)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// publish hands accepted events over for delivery, either by storing them
+// alongside outbox entries or by placing them straight on the queue. If that
+// fails an error response is written and false is returned.
+func publish(c *gin.Context, pg *sql.DB, publisher *queue.Publisher, useOutbox bool, list []events.GenericEvent) bool {
+	if useOutbox {
+		// Store them alongside outbox entries; the relay takes care of
+		// putting them on the queue.
+		if err := db.InsertEventsWithOutbox(pg, list); err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return false
+		}
+		return true
+	}
+
+	// Queue them up and wait for the broker to confirm them.
+	ctx, cancel := context.WithTimeout(c.Request.Context(), publishTimeout)
+	defer cancel()
+	if err := publisher.EnqueueAll(ctx, list); err != nil {
+		c.JSON(http.StatusServiceUnavailable, gin.H{"errors": []string{"event queue unavailable"}})
+		log.Println(err)
+		return false
+	}
+	return true
+}
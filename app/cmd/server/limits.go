@@ -0,0 +1,138 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/auth"
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/ratelimit"
+	events "github.com/allokate-ai/events/app/pkg/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+// subject returns who an event counts against for rate limits and quotas: the
+// API key or user the request was made by, or else the event's source.
+func subject(c *gin.Context, event events.GenericEvent) string {
+	if p, ok := principal(c); ok {
+		return p.Id
+	}
+	return "source:" + event.Source
+}
+
+// limit enforces the rate limits and daily quotas on publishing the events,
+// either all of them or none. If a limit is exceeded a 429 response telling
+// when to try again is written and false is returned. Otherwise the returned
+// function takes back the quota used, for when the events can't be queued
+// after all.
+func limit(c *gin.Context, pg *sql.DB, limiter *ratelimit.Limiter, list []events.GenericEvent) (func(), bool) {
+	if limiter == nil || len(list) == 0 {
+		return func() {}, true
+	}
+
+	counts := map[ratelimit.Usage]int{}
+	for _, event := range list {
+		rule := limiter.Limits.For(event.Name)
+		counts[ratelimit.Usage{Subject: subject(c, event), Pattern: rule.Name}]++
+	}
+
+	now := time.Now()
+	if wait, ok := limiter.Take(counts, now); !ok {
+		tooManyRequests(c, wait, "rate limit exceeded")
+		return nil, false
+	}
+
+	reservations := []db.QuotaReservation{}
+	for usage, n := range counts {
+		reservations = append(reservations, db.QuotaReservation{
+			Subject: usage.Subject,
+			Name:    usage.Pattern,
+			Count:   int64(n),
+			Limit:   limiter.Limits.Rule(usage.Pattern).Daily,
+		})
+	}
+
+	exceeded, err := db.ReserveQuota(pg, now, reservations)
+	if err != nil {
+		limiter.Give(counts, now)
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+		log.Println(err)
+		return nil, false
+	}
+	if exceeded != nil {
+		limiter.Give(counts, now)
+
+		// Quotas reset at midnight UTC.
+		y, m, d := now.UTC().Date()
+		tomorrow := time.Date(y, m, d+1, 0, 0, 0, 0, time.UTC)
+		tooManyRequests(c, tomorrow.Sub(now), fmt.Sprintf("daily quota of %d %s events exceeded", exceeded.Limit, exceeded.Name))
+		return nil, false
+	}
+
+	return func() {
+		if err := db.ReleaseQuota(pg, now, reservations); err != nil {
+			log.Println(err)
+		}
+	}, true
+}
+
+// tooManyRequests responds with a 429 telling the client when to try again.
+func tooManyRequests(c *gin.Context, wait time.Duration, reason string) {
+	c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+	c.JSON(http.StatusTooManyRequests, gin.H{"errors": []string{reason}})
+}
+
+// quotaRoutes registers the endpoint for checking daily quotas.
+func quotaRoutes(router *gin.Engine, pg *sql.DB, limiter *ratelimit.Limiter) {
+	// Endpoint for fetching how many events have been published on a UTC day
+	// against each quota, along with its limit. Only admins see the usage of
+	// subjects other than their own.
+	router.GET("/api/quotas", func(c *gin.Context) {
+		day := time.Now()
+		if val, ok := c.GetQuery("day"); ok {
+			v, err := time.Parse("2006-01-02", val)
+			if err != nil {
+				c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"day must be a date such as 2022-06-30"}})
+				return
+			}
+			day = v
+		}
+
+		var subject *string
+		if val, ok := c.GetQuery("subject"); ok {
+			subject = &val
+		}
+		if p, ok := principal(c); ok && !p.Has(auth.ScopeAdmin) {
+			subject = &p.Id
+		}
+
+		list, err := db.ListQuotaUsage(pg, day, subject)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		type quota struct {
+			db.QuotaUsage
+			Limit *int64 `json:"limit"`
+		}
+		quotas := []quota{}
+		for _, usage := range list {
+			q := quota{QuotaUsage: usage}
+			if limiter != nil {
+				if daily := limiter.Limits.Rule(usage.Name).Daily; daily > 0 {
+					q.Limit = &daily
+				}
+			}
+			quotas = append(quotas, q)
+		}
+		c.JSON(http.StatusOK, quotas)
+	})
+}
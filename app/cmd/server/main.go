@@ -9,12 +9,18 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
-	"strings"
+	"strconv"
 	"time"
 
+	"github.com/allokate-ai/events/app/internal/auth"
 	"github.com/allokate-ai/events/app/internal/config"
 	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/encryption"
+	"github.com/allokate-ai/events/app/internal/outbox"
 	"github.com/allokate-ai/events/app/internal/queue"
+	"github.com/allokate-ai/events/app/internal/ratelimit"
+	"github.com/allokate-ai/events/app/internal/replay"
+	"github.com/allokate-ai/events/app/internal/schema"
 	events "github.com/allokate-ai/events/app/pkg/client"
 	"github.com/allokate-ai/events/app/pkg/validation"
 
@@ -23,6 +29,45 @@ import (
 	"github.com/google/uuid"
 )
 
+// Origins of the web apps allowed to call the API.
+var allowedOrigins = []string{"https://localhost:3000", "http://localhost:3001"}
+
+const (
+	// Number of events returned by GET /api/events when no limit is given.
+	defaultPageSize = 100
+
+	// Upper bound on the limit accepted by GET /api/events.
+	maxPageSize = 1000
+
+	// Upper bound on the number of body filters accepted by GET /api/events.
+	maxBodyFilters = 20
+
+	// Upper bound on the number of events accepted by POST /api/events:batch.
+	maxBatchSize = 1000
+
+	// Upper bound on the length of the Idempotency-Key header.
+	maxIdempotencyKeyLength = 255
+
+	// How long a retry is asked to wait while the request it repeats is still
+	// being handled.
+	idempotencyRetryAfter = time.Second
+
+	// How long to wait for the broker to confirm a published event.
+	publishTimeout = 5 * time.Second
+
+	// How long a body schema is cached before being reloaded.
+	schemaCacheTTL = time.Minute
+
+	// How often replays left behind by other server instances are looked for.
+	replayResumeInterval = time.Minute
+
+	// How long an API key is cached before being looked up again.
+	apiKeyCacheTTL = time.Minute
+
+	// How long the daily usage of quotas is kept.
+	quotaUsageRetention = 90 * 24 * time.Hour
+)
+
 func main() {
 
 	// Load the app's configuration settings.
@@ -42,35 +87,111 @@ func main() {
 		log.Fatal(err)
 	}
 
-	amqp, err := queue.Connect(config.AMQPConfig.Host, config.AMQPConfig.Port, config.AMQPConfig.Username, config.AMQPConfig.Password)
+	// Connect to the message broker.
+	publisher, err := queue.NewPublisher(config.AMQPConfig.Host, config.AMQPConfig.Port, config.AMQPConfig.Username, config.AMQPConfig.Password)
 	if err != nil {
 		log.Fatal(err)
 	}
+	defer publisher.Close()
+
+	// Relay events accepted in outbox mode to the exchange.
+	relayCtx, stopRelay := context.WithCancel(context.Background())
+	defer stopRelay()
+	if config.Outbox.Enabled {
+		go outbox.Relay(relayCtx, pg, config.Outbox.Interval, config.Outbox.BatchSize, func(event events.GenericEvent) error {
+			ctx, cancel := context.WithTimeout(relayCtx, publishTimeout)
+			defer cancel()
+			return publisher.Enqueue(ctx, event)
+		})
+	}
+
+	// Forget idempotency keys once they fall out of the window.
+	go func() {
+		for range time.Tick(time.Hour) {
+			if err := db.PurgeIdempotencyKeys(pg, config.Idempotency.Window); err != nil {
+				log.Println(err)
+			}
+		}
+	}()
 
-	if err := queue.Init(amqp); err != nil {
+	// Pick up replays that were interrupted by the last shutdown, and later
+	// on those of other instances that went away.
+	runner := replay.NewRunner(pg, publisher)
+	if err := runner.Resume(); err != nil {
 		log.Fatal(err)
 	}
+	go runner.ResumeEvery(context.Background(), replayResumeInterval)
 
-	ch, err := amqp.Channel()
+	// Personal fields of event bodies are encrypted if master keys are
+	// configured.
+	encrypter, err := newEncrypter(config.Encryption)
 	if err != nil {
 		log.Fatal(err)
 	}
+	decrypter := decrypter{encrypter: encrypter, token: config.Encryption.DecryptToken}
+
+	// Publishing is rate limited if limits are configured.
+	var limiter *ratelimit.Limiter
+	if config.RateLimit.File != "" {
+		limits, err := ratelimit.Load(config.RateLimit.File)
+		if err != nil {
+			log.Fatal(err)
+		}
+		limiter = ratelimit.NewLimiter(limits)
+
+		go func() {
+			for now := range time.Tick(time.Minute) {
+				limiter.Sweep(now)
+			}
+		}()
+		go func() {
+			for now := range time.Tick(time.Hour) {
+				if err := db.PurgeQuotaUsage(pg, now.Add(-quotaUsageRetention)); err != nil {
+					log.Println(err)
+				}
+			}
+		}()
+	}
+
+	// Body schemas are cached briefly to keep validation off the database.
+	registry := schema.NewRegistry(pg, schemaCacheTTL)
 
-	router := gin.Default()
+	router := gin.New()
+	router.Use(streamCredentials(), gin.Logger(), gin.Recovery())
 
 	router.Use(cors.New(cors.Config{
-		AllowedOrigins:   []string{"https://localhost:3000", "http://localhost:3001"},
-		AllowedMethods:   []string{"PUT", "PATCH", "POST", "OPTIONS"},
-		AllowedHeaders:   []string{"Origin", "Authorization"},
-		ExposedHeaders:   []string{"Content-Length"},
+		AllowedOrigins:   allowedOrigins,
+		AllowedMethods:   []string{"PUT", "PATCH", "POST", "DELETE", "OPTIONS"},
+		AllowedHeaders:   []string{"Origin", "Authorization", "X-Decrypt-Token", "Idempotency-Key"},
+		ExposedHeaders:   []string{"Content-Length", "Idempotent-Replayed", "Retry-After"},
 		AllowCredentials: false,
 		MaxAge:           12 * time.Hour,
 	}))
 
+	// Require API keys if enabled.
+	authenticator := auth.NewAuthenticator(pg, apiKeyCacheTTL)
+	if config.Auth.Enabled {
+		// People may also read events with tokens from the identity
+		// provider, if one is configured.
+		var verifier *auth.TokenVerifier
+		if config.Auth.JWT.JWKS != "" {
+			verifier, err = auth.NewTokenVerifier(auth.TokenOptions{
+				JWKS:            config.Auth.JWT.JWKS,
+				Issuer:          config.Auth.JWT.Issuer,
+				Audience:        config.Auth.JWT.Audience,
+				RolesClaim:      config.Auth.JWT.RolesClaim,
+				AdminRole:       config.Auth.JWT.AdminRole,
+				RestrictedNames: config.Auth.JWT.RestrictedNames,
+			})
+			if err != nil {
+				log.Fatal(err)
+			}
+		}
+		router.Use(authenticate(authenticator, verifier))
+	}
+
 	// Endpoint for publishing new events.
 	router.PUT("/api/events", func(c *gin.Context) {
-		event := events.GenericEvent{}
-
 		// Read request body.
 		data, err := ioutil.ReadAll(c.Request.Body)
 		if err != nil {
@@ -90,95 +211,262 @@ func main() {
 		}
 
 		// Validate the request.
-		errors := []string{}
+		event, errors, err := parseEvent(body, registry)
+		if len(c.GetHeader("Idempotency-Key")) > maxIdempotencyKeyLength {
+			errors = append(errors, fmt.Sprintf("Idempotency-Key must be at most %d characters", maxIdempotencyKeyLength))
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
 
-		// Validate optional timestamp field.
-		if val, ok := body["timestamp"]; ok {
-			if s, ok := val.(string); !ok {
-				errors = append(errors, "timestamp must be a string")
-			} else {
-				if v, err := time.Parse(time.RFC3339, s); err != nil {
-					errors = append(errors, "timestamp must be an RFC-3339 compliant string")
-				} else {
-					event.Timestamp = v
+		// Return any errors if appropriate.
+		if len(errors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": errors,
+			})
+			return
+		}
+
+		// Make sure the API key may publish the event.
+		if reason := publishError(c, event); reason != "" {
+			c.JSON(http.StatusForbidden, gin.H{"errors": []string{reason}})
+			return
+		}
+
+		// Replays of an event published within the idempotency window get the
+		// original event back and aren't queued again.
+		key := idempotencyKey(c.GetHeader("Idempotency-Key"), event)
+
+		// Generate a unique ID for the new event, unless the client supplied
+		// one, before placing it on the queue.
+		if event.Id == "" {
+			event.Id = uuid.New().String()
+		}
+
+		// Personal fields are encrypted before the event is stored or queued
+		// anywhere.
+		sealed, err := encrypter.Encrypt(event)
+		if err == encryption.ErrReservedField {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{err.Error()}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"encryption error"}})
+			log.Println(err)
+			return
+		}
+
+		if key != "" {
+			original, claimed, err := db.ClaimIdempotencyKey(pg, key, requestHash(body), sealed, config.Idempotency.Window)
+			if err == db.ErrIdempotencyKeyPending {
+				c.Header("Retry-After", strconv.Itoa(int(idempotencyRetryAfter.Seconds())))
+				c.JSON(http.StatusConflict, gin.H{"errors": []string{"a request with the same idempotency key is in progress"}})
+				return
+			}
+			if err == db.ErrIdempotencyKeyReused {
+				c.JSON(http.StatusUnprocessableEntity, gin.H{"errors": []string{"idempotency key was already used for a different event"}})
+				return
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+				log.Println(err)
+				return
+			}
+			if !claimed {
+				c.Header("Idempotent-Replayed", "true")
+				c.JSON(http.StatusOK, decrypter.event(c, original))
+				return
+			}
+		}
+
+		// Hold back producers publishing too much.
+		release, ok := limit(c, pg, limiter, []events.GenericEvent{event})
+		if !ok {
+			if key != "" {
+				if err := db.ReleaseIdempotencyKeys(pg, []string{key}); err != nil {
+					log.Println(err)
+				}
+			}
+			return
+		}
+
+		// Queue it up.
+		if !publish(c, pg, publisher, config.Outbox.Enabled, []events.GenericEvent{sealed}) {
+			release()
+			if key != "" {
+				if err := db.ReleaseIdempotencyKeys(pg, []string{key}); err != nil {
+					log.Println(err)
 				}
 			}
-		} else {
-			event.Timestamp = time.Now()
+			return
 		}
 
-		// Validate required name field.
-		if val, ok := body["name"]; ok {
-			if s, ok := val.(string); !ok {
-				errors = append(errors, "name must be a string")
-			} else {
-				event.Name = strings.ReplaceAll(strings.ReplaceAll(s, " ", "-"), " ", "-")
+		// Retries get the event back from now on.
+		if key != "" {
+			if err := db.CompleteIdempotencyKeys(pg, []string{key}); err != nil {
+				log.Println(err)
 			}
-		} else {
-			errors = append(errors, "name is required")
 		}
 
-		// Validate required source field.
-		if val, ok := body["source"]; ok {
-			if s, ok := val.(string); !ok {
-				errors = append(errors, "source must be a string")
+		c.JSON(http.StatusOK, event)
+	})
+
+	// Endpoint for publishing several events at once. Gin can't route a
+	// literal colon so the action is matched here instead.
+	router.POST("/api/events:action", func(c *gin.Context) {
+		if c.Param("action") != ":batch" {
+			c.JSON(http.StatusNotFound, gin.H{"errors": []string{"no such action"}})
+			return
+		}
+
+		// Read request body.
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": "failed to read request body",
+			})
+			return
+		}
+
+		// Decode request body as a list of generic JSON values.
+		var items []json.RawMessage
+		if err := json.Unmarshal(data, &items); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": "failed to parse json",
+			})
+			return
+		}
+
+		if len(items) == 0 || len(items) > maxBatchSize {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": []string{fmt.Sprintf("between 1 and %d events are required", maxBatchSize)},
+			})
+			return
+		}
+
+		// Validate each event on its own.
+		results := make([]events.BatchResult, len(items))
+		accepted := []events.GenericEvent{}
+		keys := []string{}
+
+		// Items repeating an ID get the result of the first item with it.
+		firsts := map[string]int{}
+		hashes := make([]string, len(items))
+		for i, item := range items {
+			var body map[string]any
+			if err := json.Unmarshal(item, &body); err != nil || body == nil {
+				results[i].Errors = []string{"event must be a JSON object"}
+				continue
+			}
+
+			event, errors, err := parseEvent(body, registry)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+				log.Println(err)
+				if err := db.ReleaseIdempotencyKeys(pg, keys); err != nil {
+					log.Println(err)
+				}
+				return
+			}
+			if len(errors) > 0 {
+				results[i].Errors = errors
+				continue
+			}
+			if reason := publishError(c, event); reason != "" {
+				results[i].Errors = []string{reason}
+				continue
+			}
+
+			// Events carrying their own ID are only queued once.
+			key := ""
+			if event.Id == "" {
+				event.Id = uuid.New().String()
 			} else {
-				event.Source = strings.ReplaceAll(strings.ReplaceAll(s, " ", "-"), " ", "-")
+				key = idempotencyKey("", event)
+				hashes[i] = requestHash(body)
+				if first, ok := firsts[key]; ok {
+					if hashes[first] != hashes[i] {
+						results[i].Errors = []string{"id was already used for a different event"}
+					} else {
+						results[i] = results[first]
+					}
+					continue
+				}
+				firsts[key] = i
+			}
+
+			// Personal fields are encrypted before the event is stored or
+			// queued anywhere.
+			event, err = encrypter.Encrypt(event)
+			if err == encryption.ErrReservedField {
+				results[i].Errors = []string{err.Error()}
+				continue
+			}
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"encryption error"}})
+				log.Println(err)
+				if err := db.ReleaseIdempotencyKeys(pg, keys); err != nil {
+					log.Println(err)
+				}
+				return
 			}
-		} else {
-			errors = append(errors, "source is required")
-		}
-
-		// Validate optional body field.
-		if val, ok := body["body"]; ok {
-			switch v := val.(type) {
-			case string:
-				if validation.IsJSON(v) {
-					event.Body = []byte(v)
-				} else {
-					errors = append(errors, "body must be valid JSON object")
+
+			if key != "" {
+				original, claimed, err := db.ClaimIdempotencyKey(pg, key, hashes[i], event, config.Idempotency.Window)
+				if err == db.ErrIdempotencyKeyPending {
+					results[i].Errors = []string{"an event with the same id is being published"}
+					continue
 				}
-			case []byte:
-				if validation.IsJSON(v) {
-					event.Body = v
-				} else {
-					errors = append(errors, "body must be valid JSON object")
+				if err == db.ErrIdempotencyKeyReused {
+					results[i].Errors = []string{"id was already used for a different event"}
+					continue
 				}
-			case map[string]any:
-				b, err := json.Marshal(&v)
 				if err != nil {
-					errors = append(errors, "body must be valid JSON object")
-				} else {
-					event.Body = b
+					c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+					log.Println(err)
+					if err := db.ReleaseIdempotencyKeys(pg, keys); err != nil {
+						log.Println(err)
+					}
+					return
+				}
+				if !claimed {
+					results[i].Id = original.Id
+					continue
 				}
-			case nil:
-				event.Body = []byte{}
-			default:
-				errors = append(errors, "body must be valid JSON object")
+				keys = append(keys, key)
 			}
 
-		} else {
-			event.Body = nil
+			results[i].Id = event.Id
+			accepted = append(accepted, event)
 		}
 
-		// Return any errors if appropriate.
-		if len(errors) > 0 {
-			c.JSON(http.StatusBadRequest, gin.H{
-				"errors": errors,
-			})
+		// Hold back producers publishing too much. The whole batch is
+		// rejected so that it can be retried as is.
+		release, ok := limit(c, pg, limiter, accepted)
+		if !ok {
+			if err := db.ReleaseIdempotencyKeys(pg, keys); err != nil {
+				log.Println(err)
+			}
 			return
 		}
 
-		// Generate a unique ID for the new event before placing it on the queue.
-		event.Id = uuid.New().String()
+		// Queue up the valid events together.
+		if len(accepted) > 0 && !publish(c, pg, publisher, config.Outbox.Enabled, accepted) {
+			release()
+			if err := db.ReleaseIdempotencyKeys(pg, keys); err != nil {
+				log.Println(err)
+			}
+			return
+		}
 
-		// Queue it up.
-		if err := queue.Enqueue(ch, event); err != nil {
-			c.JSON(http.StatusInternalServerError, event)
-			log.Fatal(err)
+		// Retries get the events back from now on.
+		if err := db.CompleteIdempotencyKeys(pg, keys); err != nil {
+			log.Println(err)
 		}
 
-		c.JSON(http.StatusOK, event)
+		c.JSON(http.StatusOK, gin.H{"results": results})
 	})
 
 	// Endpoint for fetching a list of events.
@@ -186,41 +474,30 @@ func main() {
 		// Validate the request.
 		errors := []string{}
 
-		var from *time.Time
-		var to *time.Time
-		var name *string
-		var source *string
+		filter, filterErrors := parseEventFilter(c)
+		errors = append(errors, filterErrors...)
+
+		limit := defaultPageSize
+		var cursor *db.Cursor
 
-		// Validate optional from field.
-		if val, ok := c.GetQuery("from"); ok {
-			if v, err := time.Parse(time.RFC3339, val); err != nil {
-				errors = append(errors, "from must be an RFC-3339 compliant string")
+		// Validate optional limit field.
+		if val, ok := c.GetQuery("limit"); ok {
+			if v, err := strconv.Atoi(val); err != nil || v < 1 || v > maxPageSize {
+				errors = append(errors, fmt.Sprintf("limit must be an integer between 1 and %d", maxPageSize))
 			} else {
-				from = &v
+				limit = v
 			}
 		}
 
-		// Validate optional to field.
-		if val, ok := c.GetQuery("to"); ok {
-			if v, err := time.Parse(time.RFC3339, val); err != nil {
-				errors = append(errors, "to must be an RFC-3339 compliant string")
+		// Validate optional cursor field.
+		if val, ok := c.GetQuery("cursor"); ok {
+			if v, err := db.ParseCursor(val); err != nil {
+				errors = append(errors, "cursor must be a value previously returned as next_cursor")
 			} else {
-				to = &v
+				cursor = &v
 			}
 		}
 
-		if v, ok := c.GetQuery("name"); !ok {
-			name = nil
-		} else {
-			name = &v
-		}
-
-		if v, ok := c.GetQuery("source"); !ok {
-			source = nil
-		} else {
-			source = &v
-		}
-
 		// Return any errors if appropriate.
 		if len(errors) > 0 {
 			c.JSON(http.StatusBadRequest, gin.H{
@@ -229,13 +506,18 @@ func main() {
 			return
 		}
 
-		events, err := db.ListEvents(pg, from, to, name, source)
+		list, next, err := db.ListEvents(pg, filter, limit, cursor)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
 			log.Println(err)
 			return
 		}
-		c.JSON(http.StatusOK, events)
+
+		page := events.EventPage{Events: decrypter.events(c, list)}
+		if next != nil {
+			page.NextCursor = next.String()
+		}
+		c.JSON(http.StatusOK, page)
 	})
 
 	// Endpoint for fetching a list of unique event names.
@@ -283,9 +565,26 @@ func main() {
 			log.Println(err)
 			return
 		}
-		c.JSON(http.StatusOK, event)
+		if !canRead(c, event.Name) {
+			c.JSON(http.StatusForbidden, gin.H{"errors": []string{fmt.Sprintf("may not read %s events", event.Name)}})
+			return
+		}
+		c.JSON(http.StatusOK, decrypter.event(c, event))
 	})
 
+	schemaRoutes(router, pg, registry)
+	searchRoutes(router, pg, decrypter)
+	statsRoutes(router, pg)
+	erasureRoutes(router, pg, publisher, encrypter, config.Outbox.Enabled)
+	encryptionRoutes(router, pg, encrypter)
+	streamRoutes(router, publisher)
+	websocketRoutes(router, pg, publisher, allowedOrigins)
+	replayRoutes(router, pg, runner)
+	deadLetterRoutes(router, publisher)
+	retentionRoutes(router, pg)
+	apiKeyRoutes(router, pg, authenticator)
+	quotaRoutes(router, pg, limiter)
+
 	// Create a server and service incoming connections.
 	server := &http.Server{
 		Addr:    fmt.Sprintf(":%d", config.Port),
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/replay"
+	"github.com/allokate-ai/events/app/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+// Names the broker accepts for exchanges.
+var exchangeName = regexp.MustCompile(`^[A-Za-z0-9_.:-]{1,255}$`)
+
+// Number of replays returned by GET /api/replays.
+const replayListSize = 50
+
+// replayRoutes registers the endpoints for re-publishing stored events.
+func replayRoutes(router *gin.Engine, pg *sql.DB, runner *replay.Runner) {
+	// Endpoint for starting a replay of stored events.
+	router.POST("/api/replays", func(c *gin.Context) {
+		// Read request body.
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": "failed to read request body",
+			})
+			return
+		}
+
+		// Decode request body.
+		var body struct {
+			From       *string `json:"from"`
+			To         *string `json:"to"`
+			Name       *string `json:"name"`
+			Source     *string `json:"source"`
+			Exchange   string  `json:"exchange"`
+			RoutingKey string  `json:"routing_key"`
+			Rate       int     `json:"rate"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": "failed to parse json",
+			})
+			return
+		}
+
+		// Validate the request.
+		errors := []string{}
+		job := db.Replay{
+			Id:         uuid.New().String(),
+			Status:     db.ReplayPending,
+			Exchange:   "events",
+			RoutingKey: body.RoutingKey,
+			Rate:       body.Rate,
+		}
+
+		// Validate optional from field.
+		if body.From != nil {
+			if v, err := time.Parse(time.RFC3339, *body.From); err != nil {
+				errors = append(errors, "from must be an RFC-3339 compliant string")
+			} else {
+				job.Filter.From = &v
+			}
+		}
+
+		// Validate optional to field. Without one the replay stops at the
+		// events stored by the time it was requested.
+		if body.To != nil {
+			if v, err := time.Parse(time.RFC3339, *body.To); err != nil {
+				errors = append(errors, "to must be an RFC-3339 compliant string")
+			} else {
+				job.Filter.To = &v
+			}
+		} else {
+			now := time.Now()
+			job.Filter.To = &now
+		}
+
+		job.Filter.Name = body.Name
+		job.Filter.Source = body.Source
+
+		// Validate optional exchange field.
+		if body.Exchange != "" {
+			if !exchangeName.MatchString(body.Exchange) || strings.HasPrefix(body.Exchange, "amq.") {
+				errors = append(errors, "exchange must be a valid exchange name that doesn't start with amq.")
+			} else {
+				job.Exchange = body.Exchange
+			}
+		}
+
+		// Validate optional routing_key field.
+		if len(body.RoutingKey) > maxPatternLength {
+			errors = append(errors, fmt.Sprintf("routing_key must be at most %d characters", maxPatternLength))
+		}
+
+		// Validate optional rate field.
+		if body.Rate < 0 {
+			errors = append(errors, "rate must be a positive number of events per second")
+		}
+
+		// Return any errors if appropriate.
+		if len(errors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": errors,
+			})
+			return
+		}
+
+		total, err := db.CountEvents(pg, job.Filter)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		job.Total = total
+
+		job, err = db.CreateReplay(pg, job)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		// Should it not start here, another instance picks it up later on.
+		if err := runner.Start(job); err != nil {
+			log.Println(err)
+		}
+
+		c.JSON(http.StatusAccepted, job)
+	})
+
+	// Endpoint for fetching the most recent replays.
+	router.GET("/api/replays", func(c *gin.Context) {
+		list, err := db.ListReplays(pg, replayListSize)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	// Endpoint for following the progress of a replay.
+	router.GET("/api/replays/:id", func(c *gin.Context) {
+		job, ok := findReplay(c, pg)
+		if !ok {
+			return
+		}
+		c.JSON(http.StatusOK, job)
+	})
+
+	// Endpoint for cancelling a replay.
+	router.DELETE("/api/replays/:id", func(c *gin.Context) {
+		job, ok := findReplay(c, pg)
+		if !ok {
+			return
+		}
+
+		// Marking it as cancelled stops the replay wherever it runs, and it is
+		// stopped right away if that is here.
+		cancelled, err := db.CancelReplay(pg, job.Id)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		if !cancelled {
+			c.JSON(http.StatusConflict, gin.H{"errors": []string{"replay has already finished"}})
+			return
+		}
+		runner.Cancel(job.Id)
+
+		c.Status(http.StatusAccepted)
+	})
+}
+
+// findReplay looks up the replay named by the id parameter, writing an error
+// response if there isn't one.
+func findReplay(c *gin.Context, pg *sql.DB) (db.Replay, bool) {
+	id := c.Param("id")
+	if !validation.IsValidUUID(id) {
+		c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"id must be a valid UUID4 string"}})
+		return db.Replay{}, false
+	}
+
+	job, err := db.GetReplay(pg, id)
+	if errors.Is(err, db.ErrNoSuchReplay) {
+		c.JSON(http.StatusNotFound, gin.H{"errors": []string{err.Error()}})
+		return job, false
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+		log.Println(err)
+		return job, false
+	}
+
+	return job, true
+}
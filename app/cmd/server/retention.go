@@ -0,0 +1,89 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"net/http"
+
+	"github.com/allokate-ai/events/app/internal/db"
+
+	"github.com/gin-gonic/gin"
+)
+
+// retentionRoutes registers the endpoints for managing how long events are
+// kept.
+func retentionRoutes(router *gin.Engine, pg *sql.DB) {
+	// Endpoint for fetching every retention policy.
+	router.GET("/api/retention", func(c *gin.Context) {
+		list, err := db.ListRetentionPolicies(pg)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		c.JSON(http.StatusOK, list)
+	})
+
+	// Endpoint for setting how long events with a name are kept. The name *
+	// sets the policy for events without one of their own.
+	router.PUT("/api/retention/:name", func(c *gin.Context) {
+		name := c.Param("name")
+		if len(name) > maxPatternLength {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{fmt.Sprintf("name must be at most %d characters", maxPatternLength)}})
+			return
+		}
+
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": "failed to read request body",
+			})
+			return
+		}
+
+		// A missing or null keep_days keeps the events forever.
+		var body struct {
+			KeepDays *int `json:"keep_days"`
+		}
+		if err := json.Unmarshal(data, &body); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": "failed to parse json",
+			})
+			return
+		}
+
+		if body.KeepDays != nil && *body.KeepDays < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"keep_days must be a positive number of days or null"}})
+			return
+		}
+
+		policy, err := db.PutRetentionPolicy(pg, name, body.KeepDays)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, policy)
+	})
+
+	// Endpoint for removing the retention policy for a name.
+	router.DELETE("/api/retention/:name", func(c *gin.Context) {
+		err := db.DeleteRetentionPolicy(pg, c.Param("name"))
+		if errors.Is(err, db.ErrNoSuchRetentionPolicy) {
+			c.JSON(http.StatusNotFound, gin.H{"errors": []string{err.Error()}})
+			return
+		}
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		c.Status(http.StatusNoContent)
+	})
+}
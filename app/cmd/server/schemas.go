@@ -0,0 +1,124 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/schema"
+
+	"github.com/gin-gonic/gin"
+)
+
+// schemaRoutes registers the endpoints for managing the schema registry.
+func schemaRoutes(router *gin.Engine, pg *sql.DB, registry *schema.Registry) {
+	// Endpoint for fetching the latest version of every registered schema.
+	router.GET("/api/schemas", func(c *gin.Context) {
+		schemas, err := db.ListSchemas(pg)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		c.JSON(http.StatusOK, schemas)
+	})
+
+	// Endpoint for fetching the latest version of a schema.
+	router.GET("/api/schemas/:name", func(c *gin.Context) {
+		getSchema(c, pg, c.Param("name"), 0)
+	})
+
+	// Endpoint for fetching a specific version of a schema.
+	router.GET("/api/schemas/:name/:version", func(c *gin.Context) {
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil || version < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"version must be a positive integer"}})
+			return
+		}
+		getSchema(c, pg, c.Param("name"), version)
+	})
+
+	// Endpoint for registering a new version of a schema.
+	router.PUT("/api/schemas/:name", func(c *gin.Context) {
+		name := c.Param("name")
+
+		data, err := ioutil.ReadAll(c.Request.Body)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{
+				"errors": "failed to read request body",
+			})
+			return
+		}
+
+		if !json.Valid(data) {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"schema must be valid JSON"}})
+			return
+		}
+
+		if _, err := schema.Compile(name, data); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"schema is invalid: " + err.Error()}})
+			return
+		}
+
+		s, err := db.CreateSchema(pg, name, data)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+		registry.Invalidate(name)
+
+		c.JSON(http.StatusCreated, s)
+	})
+
+	// Endpoint for removing every version of a schema, which turns off
+	// validation for the event.
+	router.DELETE("/api/schemas/:name", func(c *gin.Context) {
+		deleteSchema(c, pg, registry, c.Param("name"), 0)
+	})
+
+	// Endpoint for removing a specific version of a schema.
+	router.DELETE("/api/schemas/:name/:version", func(c *gin.Context) {
+		version, err := strconv.Atoi(c.Param("version"))
+		if err != nil || version < 1 {
+			c.JSON(http.StatusBadRequest, gin.H{"errors": []string{"version must be a positive integer"}})
+			return
+		}
+		deleteSchema(c, pg, registry, c.Param("name"), version)
+	})
+}
+
+func getSchema(c *gin.Context, pg *sql.DB, name string, version int) {
+	s, err := db.GetSchema(pg, name, version)
+	if errors.Is(err, db.ErrNoSuchSchema) {
+		c.JSON(http.StatusNotFound, gin.H{"errors": []string{err.Error()}})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+		log.Println(err)
+		return
+	}
+	c.JSON(http.StatusOK, s)
+}
+
+func deleteSchema(c *gin.Context, pg *sql.DB, registry *schema.Registry, name string, version int) {
+	err := db.DeleteSchema(pg, name, version)
+	if errors.Is(err, db.ErrNoSuchSchema) {
+		c.JSON(http.StatusNotFound, gin.H{"errors": []string{err.Error()}})
+		return
+	}
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+		log.Println(err)
+		return
+	}
+	registry.Invalidate(name)
+
+	c.Status(http.StatusNoContent)
+}
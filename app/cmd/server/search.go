@@ -0,0 +1,88 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	events "github.com/allokate-ai/events/app/pkg/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Longest search query accepted.
+	maxSearchQueryLength = 1000
+
+	// How deep into the results of a search pages may go, as every page is
+	// ranked from the top.
+	maxSearchOffset = 10000
+)
+
+// searchRoutes registers the endpoint for searching the text of events.
+func searchRoutes(router *gin.Engine, pg *sql.DB, decrypter decrypter) {
+	// Endpoint for full-text search over event bodies, best match first.
+	router.GET("/api/events/search", func(c *gin.Context) {
+		// Validate the request.
+		errors := []string{}
+
+		query := c.Query("q")
+		if query == "" || len(query) > maxSearchQueryLength {
+			errors = append(errors, fmt.Sprintf("q must be between 1 and %d characters", maxSearchQueryLength))
+		}
+
+		filter, filterErrors := parseEventFilter(c)
+		errors = append(errors, filterErrors...)
+
+		limit := defaultPageSize
+		var cursor *db.SearchCursor
+
+		// Validate optional limit field.
+		if val, ok := c.GetQuery("limit"); ok {
+			if v, err := strconv.Atoi(val); err != nil || v < 1 || v > maxPageSize {
+				errors = append(errors, fmt.Sprintf("limit must be an integer between 1 and %d", maxPageSize))
+			} else {
+				limit = v
+			}
+		}
+
+		// Validate optional cursor field.
+		if val, ok := c.GetQuery("cursor"); ok {
+			if v, err := db.ParseSearchCursor(val); err != nil {
+				errors = append(errors, "cursor must be a value previously returned as next_cursor")
+			} else if v.Offset >= maxSearchOffset {
+				errors = append(errors, fmt.Sprintf("search results can only be paged through up to the first %d", maxSearchOffset))
+			} else {
+				cursor = &v
+			}
+		}
+
+		// Return any errors if appropriate.
+		if len(errors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": errors,
+			})
+			return
+		}
+
+		list, next, err := db.SearchEvents(pg, query, filter, limit, cursor)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		for i := range list {
+			list[i].Event = decrypter.event(c, list[i].Event)
+		}
+
+		page := events.SearchPage{Results: list}
+		if next != nil && next.Offset < maxSearchOffset {
+			page.NextCursor = next.String()
+		}
+		c.JSON(http.StatusOK, page)
+	})
+}
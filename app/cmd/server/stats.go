@@ -0,0 +1,100 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	events "github.com/allokate-ai/events/app/pkg/client"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	// Number of buckets covered when no from is given.
+	defaultStatsBuckets = 100
+
+	// Upper bound on the number of buckets covered by a single request.
+	maxStatsBuckets = 10000
+)
+
+// statsRoutes registers the endpoint for counting events over time.
+func statsRoutes(router *gin.Engine, pg *sql.DB) {
+	// Endpoint for counting events in buckets of a minute, hour or day,
+	// optionally grouped by name and/or source.
+	router.GET("/api/events/stats", func(c *gin.Context) {
+		// Validate the request.
+		errors := []string{}
+
+		filter, filterErrors := parseEventFilter(c)
+		errors = append(errors, filterErrors...)
+
+		// Validate optional interval field.
+		interval := c.DefaultQuery("interval", "hour")
+		step, ok := db.StatsIntervals[interval]
+		if !ok {
+			errors = append(errors, "interval must be one of minute, hour or day")
+		}
+
+		// Validate optional group_by fields.
+		groupBy := []string{}
+		seen := map[string]bool{}
+		for _, group := range c.QueryArray("group_by") {
+			if !db.StatsGroups[group] {
+				errors = append(errors, "group_by must be name or source")
+				continue
+			}
+			if !seen[group] {
+				seen[group] = true
+				groupBy = append(groupBy, group)
+			}
+		}
+
+		// Validate optional rollup field.
+		rollup := c.Query("rollup") == "true"
+		if rollup && (interval == "minute" || len(filter.Body) > 0) {
+			errors = append(errors, "rollup only supports the hour and day intervals without body filters")
+		}
+
+		// The rollup table can't leave out the events a caller may not read,
+		// so those callers get counts from the events themselves.
+		if len(filter.NamePatterns) > 0 || len(filter.ExcludedNamePatterns) > 0 {
+			rollup = false
+		}
+
+		// Bound the range so that a request can't cover too many buckets.
+		if ok {
+			if filter.To == nil {
+				to := time.Now()
+				filter.To = &to
+			}
+			if filter.From == nil {
+				from := filter.To.Add(-defaultStatsBuckets * step)
+				filter.From = &from
+			}
+			if filter.To.Sub(*filter.From) > maxStatsBuckets*step {
+				errors = append(errors, fmt.Sprintf("from and to may be at most %d %ss apart", maxStatsBuckets, interval))
+			}
+		}
+
+		// Return any errors if appropriate.
+		if len(errors) > 0 {
+			c.JSON(http.StatusBadRequest, gin.H{
+				"errors": errors,
+			})
+			return
+		}
+
+		buckets, err := db.CountEventsOverTime(pg, filter, interval, groupBy, rollup)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"errors": []string{"database error"}})
+			log.Println(err)
+			return
+		}
+
+		c.JSON(http.StatusOK, events.Stats{Interval: interval, Buckets: buckets})
+	})
+}
@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/queue"
+	events "github.com/allokate-ai/events/app/pkg/client"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+)
+
+// How often a comment is sent down an idle stream to keep proxies from
+// closing it.
+const keepAliveInterval = 15 * time.Second
+
+// streamRoutes registers the endpoint for following events as they are
+// published.
+func streamRoutes(router *gin.Engine, publisher *queue.Publisher) {
+	// Endpoint for streaming newly published events as server-sent events.
+	// The name and source parameters accept topic patterns and may be given
+	// more than once.
+	router.GET("/api/events/stream", func(c *gin.Context) {
+		names := c.QueryArray("name")
+		if len(names) == 0 {
+			names = []string{"#"}
+		}
+		sources := c.QueryArray("source")
+
+		ch, err := publisher.Channel()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"errors": []string{"event queue unavailable"}})
+			log.Println(err)
+			return
+		}
+		defer ch.Close()
+
+		// The event name is the routing key, so the exchange does the
+		// filtering by name; sources are filtered here.
+		_, deliveries, err := queue.Subscribe(ch, names)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"errors": []string{"event queue unavailable"}})
+			log.Println(err)
+			return
+		}
+
+		c.Header("Cache-Control", "no-cache")
+		c.Header("X-Accel-Buffering", "no")
+
+		keepAlive := time.NewTicker(keepAliveInterval)
+		defer keepAlive.Stop()
+
+		c.Stream(func(w io.Writer) bool {
+			select {
+			case <-c.Request.Context().Done():
+				return false
+			case <-keepAlive.C:
+				_, err := io.WriteString(w, ": keep-alive\n\n")
+				return err == nil
+			case d, ok := <-deliveries:
+				if !ok {
+					return false
+				}
+
+				var event events.GenericEvent
+				if err := json.Unmarshal(d.Body, &event); err != nil {
+					log.Println(err)
+					return true
+				}
+
+				if !matchesAny(sources, event.Source) || !canRead(c, event.Name) {
+					return true
+				}
+
+				c.Render(-1, sse.Event{
+					Id:    event.Id,
+					Event: event.Name,
+					Data:  event,
+				})
+				return true
+			}
+		})
+	})
+}
+
+// matchesAny reports whether the value matches one of the topic patterns, or
+// whether there are no patterns at all.
+func matchesAny(patterns []string, value string) bool {
+	if len(patterns) == 0 {
+		return true
+	}
+	for _, pattern := range patterns {
+		if queue.MatchTopic(pattern, value) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,246 @@
+package main
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/queue"
+	events "github.com/allokate-ai/events/app/pkg/client"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// Upper bound on the number of past events replayed per subscription.
+	maxReplay = 1000
+
+	// Longest routing key pattern accepted by the broker.
+	maxPatternLength = 255
+
+	// How often the server pings the client, and how long it waits for the
+	// matching pong before giving up on the connection.
+	pingInterval = 30 * time.Second
+	pongTimeout  = 60 * time.Second
+
+	// How long a single write to the client may take.
+	writeTimeout = 10 * time.Second
+)
+
+// A message sent by the client to change its subscriptions.
+type wsRequest struct {
+	Type    string `json:"type"` // "subscribe" or "unsubscribe"
+	Pattern string `json:"pattern"`
+	Replay  int    `json:"replay,omitempty"`
+}
+
+// A message sent to the client.
+type wsMessage struct {
+	Type    string               `json:"type"` // "subscribed", "unsubscribed", "event" or "error"
+	Pattern string               `json:"pattern,omitempty"`
+	Replay  bool                 `json:"replay,omitempty"`
+	Event   *events.GenericEvent `json:"event,omitempty"`
+	Error   string               `json:"error,omitempty"`
+}
+
+// wsConn serializes writes to a websocket connection.
+type wsConn struct {
+	mu   sync.Mutex
+	conn *websocket.Conn
+}
+
+func (c *wsConn) send(msg wsMessage) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	return c.conn.WriteJSON(msg)
+}
+
+func (c *wsConn) ping() error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(writeTimeout))
+}
+
+// websocketRoutes registers the endpoint for subscribing to events over a
+// websocket.
+func websocketRoutes(router *gin.Engine, pg *sql.DB, publisher *queue.Publisher, origins []string) {
+	upgrader := websocket.Upgrader{
+		// Browsers offering a token as a subprotocol expect one to be
+		// picked.
+		Subprotocols: []string{bearerProtocol},
+		CheckOrigin: func(r *http.Request) bool {
+			origin := r.Header.Get("Origin")
+			if origin == "" {
+				return true
+			}
+			for _, allowed := range origins {
+				if origin == allowed {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	// Endpoint for subscribing to events over a websocket. Clients send
+	// subscribe and unsubscribe messages carrying routing key patterns and
+	// receive every event published under the patterns they subscribed to,
+	// optionally preceded by a replay of the latest matching events.
+	router.GET("/api/events/ws", func(c *gin.Context) {
+		ch, err := publisher.Channel()
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"errors": []string{"event queue unavailable"}})
+			log.Println(err)
+			return
+		}
+		defer ch.Close()
+
+		// Start off without bindings; they are added as the client
+		// subscribes.
+		name, deliveries, err := queue.Subscribe(ch, nil)
+		if err != nil {
+			c.JSON(http.StatusServiceUnavailable, gin.H{"errors": []string{"event queue unavailable"}})
+			log.Println(err)
+			return
+		}
+
+		ws, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+		if err != nil {
+			// The upgrader has already responded to the client.
+			log.Println(err)
+			return
+		}
+		defer ws.Close()
+
+		conn := &wsConn{conn: ws}
+		done := make(chan struct{})
+		defer close(done)
+
+		// Forward live events and keep the connection alive.
+		go func() {
+			ticker := time.NewTicker(pingInterval)
+			defer ticker.Stop()
+
+			for {
+				select {
+				case <-done:
+					return
+				case <-ticker.C:
+					if err := conn.ping(); err != nil {
+						ws.Close()
+						return
+					}
+				case d, ok := <-deliveries:
+					if !ok {
+						ws.Close()
+						return
+					}
+
+					var event events.GenericEvent
+					if err := json.Unmarshal(d.Body, &event); err != nil {
+						log.Println(err)
+						continue
+					}
+
+					if !canRead(c, event.Name) {
+						continue
+					}
+
+					if err := conn.send(wsMessage{Type: "event", Event: &event}); err != nil {
+						ws.Close()
+						return
+					}
+				}
+			}
+		}()
+
+		ws.SetReadDeadline(time.Now().Add(pongTimeout))
+		ws.SetPongHandler(func(string) error {
+			return ws.SetReadDeadline(time.Now().Add(pongTimeout))
+		})
+
+		// Handle subscription changes until the client goes away.
+		for {
+			var req wsRequest
+			if err := ws.ReadJSON(&req); err != nil {
+				var syntaxErr *json.SyntaxError
+				var typeErr *json.UnmarshalTypeError
+				if errors.As(err, &syntaxErr) || errors.As(err, &typeErr) {
+					conn.send(wsMessage{Type: "error", Error: "failed to parse json"})
+					continue
+				}
+				return
+			}
+
+			if err := handleRequest(c, conn, pg, ch, name, req); err != nil {
+				return
+			}
+		}
+	})
+}
+
+// handleRequest applies a subscription change to the client's queue. Problems
+// with the request are reported to the client; the returned error is only set
+// if the connection should be dropped.
+func handleRequest(c *gin.Context, conn *wsConn, pg *sql.DB, ch *amqp.Channel, queueName string, req wsRequest) error {
+	if req.Pattern == "" || len(req.Pattern) > maxPatternLength {
+		return conn.send(wsMessage{Type: "error", Pattern: req.Pattern, Error: fmt.Sprintf("pattern must be between 1 and %d characters", maxPatternLength)})
+	}
+
+	switch req.Type {
+	case "subscribe":
+		if req.Replay < 0 || req.Replay > maxReplay {
+			return conn.send(wsMessage{Type: "error", Pattern: req.Pattern, Error: fmt.Sprintf("replay must be between 0 and %d", maxReplay)})
+		}
+
+		if err := ch.QueueBind(queueName, req.Pattern, "events", false, nil); err != nil {
+			log.Println(err)
+			return err
+		}
+
+		if err := conn.send(wsMessage{Type: "subscribed", Pattern: req.Pattern}); err != nil {
+			return err
+		}
+
+		if req.Replay == 0 {
+			return nil
+		}
+
+		// Replay the latest matching events, oldest first, so that the client
+		// starts off from a known state.
+		filter := db.EventFilter{Pattern: &req.Pattern}
+		restrictReads(c, &filter)
+		list, _, err := db.ListEvents(pg, filter, req.Replay, nil)
+		if err != nil {
+			log.Println(err)
+			return conn.send(wsMessage{Type: "error", Pattern: req.Pattern, Error: "database error"})
+		}
+		for i := len(list) - 1; i >= 0; i-- {
+			if err := conn.send(wsMessage{Type: "event", Pattern: req.Pattern, Replay: true, Event: &list[i]}); err != nil {
+				return err
+			}
+		}
+		return nil
+
+	case "unsubscribe":
+		if err := ch.QueueUnbind(queueName, req.Pattern, "events", nil); err != nil {
+			log.Println(err)
+			return err
+		}
+		return conn.send(wsMessage{Type: "unsubscribed", Pattern: req.Pattern})
+
+	default:
+		return conn.send(wsMessage{Type: "error", Pattern: req.Pattern, Error: "type must be either subscribe or unsubscribe"})
+	}
+}
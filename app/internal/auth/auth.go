@@ -0,0 +1,228 @@
+package auth
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/queue"
+)
+
+// Scopes an API key may be granted. Publish and read may be limited to the
+// event names matching a topic pattern, as in publish:article.#; on their own
+// they cover every name. Admin grants every other scope.
+const (
+	ScopePublish = "publish"
+	ScopeRead    = "read"
+	ScopeDecrypt = "decrypt"
+	ScopeAdmin   = "admin"
+)
+
+// Prefix of every API key, which makes leaked keys easy to search for.
+const keyPrefix = "evk_"
+
+// Number of characters of a key kept in the clear to tell keys apart.
+const shownLength = len(keyPrefix) + 6
+
+// Upper bound on the length of the topic pattern of a scope.
+const maxPatternLength = 255
+
+// ErrInvalidKey is returned for keys that don't exist or have been revoked.
+var ErrInvalidKey = errors.New("invalid api key")
+
+// GenerateKey returns a new random API key along with the prefix under which
+// it is shown.
+func GenerateKey() (string, string, error) {
+	data := make([]byte, 32)
+	if _, err := rand.Read(data); err != nil {
+		return "", "", err
+	}
+	key := keyPrefix + base64.RawURLEncoding.EncodeToString(data)
+	return key, key[:shownLength], nil
+}
+
+// IsKey reports whether the secret looks like an API key rather than a token.
+func IsKey(secret string) bool {
+	return strings.HasPrefix(secret, keyPrefix)
+}
+
+// Hash returns the hash under which an API key is stored.
+func Hash(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// ParseScope checks that a scope is one of those an API key may be granted.
+func ParseScope(scope string) error {
+	name, pattern, limited := strings.Cut(scope, ":")
+	switch name {
+	case ScopePublish, ScopeRead:
+		if limited && (pattern == "" || len(pattern) > maxPatternLength) {
+			return fmt.Errorf("%q must have a pattern of between 1 and %d characters", scope, maxPatternLength)
+		}
+		return nil
+	case ScopeDecrypt, ScopeAdmin:
+		if limited {
+			return fmt.Errorf("%q can't be limited to a pattern", scope)
+		}
+		return nil
+	}
+	return fmt.Errorf("%q must be one of publish, read, decrypt or admin", scope)
+}
+
+// Patterns returns the topic patterns of the event names that the scopes
+// allow for the given use, and whether every name is allowed.
+func Patterns(scopes []string, use string) ([]string, bool) {
+	patterns := []string{}
+	for _, scope := range scopes {
+		name, pattern, limited := strings.Cut(scope, ":")
+		if name == ScopeAdmin || (name == use && !limited) {
+			return nil, true
+		}
+		if name == use {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, false
+}
+
+// Has reports whether the scopes allow the given use for at least some event
+// names.
+func Has(scopes []string, use string) bool {
+	patterns, all := Patterns(scopes, use)
+	return all || len(patterns) > 0
+}
+
+// Allows reports whether the scopes allow the given use for events with the
+// given name.
+func Allows(scopes []string, use, name string) bool {
+	patterns, all := Patterns(scopes, use)
+	if all {
+		return true
+	}
+	for _, pattern := range patterns {
+		if queue.MatchTopic(pattern, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// Principal is who a request was made by: the holder of an API key or a user
+// presenting a token from the identity provider.
+type Principal struct {
+	// Identifies the principal, as in key:<id> or user:<sub>.
+	Id   string
+	Name string
+
+	// Source the principal publishes as, if it publishes at all.
+	Source *string
+
+	Scopes []string
+
+	// Topic patterns of the event names hidden from the principal whatever
+	// its scopes.
+	Hidden []string
+}
+
+// KeyPrincipal returns the principal holding an API key.
+func KeyPrincipal(key db.APIKey) Principal {
+	return Principal{Id: "key:" + key.Id, Name: key.Name, Source: key.Source, Scopes: key.Scopes}
+}
+
+// Has reports whether the principal's scopes allow the given use for at least
+// some event names.
+func (p Principal) Has(use string) bool {
+	return Has(p.Scopes, use)
+}
+
+// Allows reports whether the principal's scopes allow the given use for events
+// with the given name.
+func (p Principal) Allows(use, name string) bool {
+	for _, pattern := range p.Hidden {
+		if queue.MatchTopic(pattern, name) {
+			return false
+		}
+	}
+	return Allows(p.Scopes, use, name)
+}
+
+// Authenticator looks up the API keys presented with requests. Keys are cached
+// for a while so that authenticating a request doesn't cost a database round
+// trip; revoking a key takes effect on other servers once it falls out of
+// their cache.
+type Authenticator struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[string]entry
+}
+
+type entry struct {
+	key     db.APIKey
+	expires time.Time
+}
+
+func NewAuthenticator(db *sql.DB, ttl time.Duration) *Authenticator {
+	return &Authenticator{
+		db:    db,
+		ttl:   ttl,
+		cache: map[string]entry{},
+	}
+}
+
+// Authenticate returns the API key matching the secret presented.
+func (a *Authenticator) Authenticate(secret string) (db.APIKey, error) {
+	if !IsKey(secret) {
+		return db.APIKey{}, ErrInvalidKey
+	}
+	hash := Hash(secret)
+
+	a.mu.Lock()
+	e, ok := a.cache[hash]
+	a.mu.Unlock()
+
+	if ok && time.Now().Before(e.expires) {
+		return e.key, nil
+	}
+
+	// Unknown keys aren't cached so that guessing can't fill up the cache.
+	key, err := db.GetAPIKeyByHash(a.db, hash)
+	if errors.Is(err, db.ErrNoSuchAPIKey) {
+		a.mu.Lock()
+		delete(a.cache, hash)
+		a.mu.Unlock()
+		return db.APIKey{}, ErrInvalidKey
+	}
+	if err != nil {
+		return db.APIKey{}, err
+	}
+
+	a.mu.Lock()
+	a.cache[hash] = entry{key: key, expires: time.Now().Add(a.ttl)}
+	a.mu.Unlock()
+
+	return key, nil
+}
+
+// Forget drops the API key with the given ID from the cache, so that revoking
+// it takes effect right away.
+func (a *Authenticator) Forget(id string) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	for hash, e := range a.cache {
+		if e.key.Id == id {
+			delete(a.cache, hash)
+		}
+	}
+}
@@ -0,0 +1,355 @@
+package auth
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math/big"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+// ErrInvalidToken is returned for tokens that fail validation.
+var ErrInvalidToken = errors.New("invalid token")
+
+// Signing algorithms accepted in tokens. Symmetric algorithms are left out as
+// a JWKS only holds public keys.
+var tokenMethods = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}
+
+const (
+	// How often the key set is reloaded.
+	jwksTTL = time.Hour
+
+	// How soon the key set may be reloaded to look for a key it doesn't
+	// hold, which the identity provider may have just rotated in.
+	jwksMinRefresh = time.Minute
+
+	// How long fetching the key set over HTTP may take.
+	jwksTimeout = 10 * time.Second
+)
+
+// TokenOptions configures a TokenVerifier.
+type TokenOptions struct {
+	// Path or HTTP(S) URL of the identity provider's JWKS.
+	JWKS string
+
+	// Values the iss and aud claims must hold.
+	Issuer   string
+	Audience string
+
+	// Dot separated path of the claim listing the user's roles, such as
+	// realm_access.roles.
+	RolesClaim string
+
+	// Role that grants access to every event.
+	AdminRole string
+
+	// Topic patterns of the event names that only admins may read.
+	RestrictedNames []string
+}
+
+// TokenVerifier validates the bearer tokens that the identity provider issues
+// to people, such as users of the dashboard. Tokens only grant read access.
+type TokenVerifier struct {
+	opts   TokenOptions
+	client *http.Client
+
+	mu        sync.Mutex
+	keys      map[string]crypto.PublicKey
+	fetched   time.Time
+	reloading chan struct{}
+}
+
+// NewTokenVerifier returns a TokenVerifier after loading the key set.
+func NewTokenVerifier(opts TokenOptions) (*TokenVerifier, error) {
+	v := &TokenVerifier{
+		opts:   opts,
+		client: &http.Client{Timeout: jwksTimeout},
+	}
+
+	keys, err := v.load()
+	if err != nil {
+		return nil, fmt.Errorf("loading JWKS: %w", err)
+	}
+	v.keys = keys
+	v.fetched = time.Now()
+
+	return v, nil
+}
+
+// Verify validates the token's signature, expiry, issuer and audience and
+// returns the user it was issued to.
+func (v *TokenVerifier) Verify(token string) (Principal, error) {
+	parsed, err := jwt.Parse(token, v.key, jwt.WithValidMethods(tokenMethods))
+	if err != nil {
+		return Principal{}, fmt.Errorf("%w: %s", ErrInvalidToken, err)
+	}
+
+	claims, ok := parsed.Claims.(jwt.MapClaims)
+	if !ok {
+		return Principal{}, ErrInvalidToken
+	}
+
+	// Parse only checks the expiry if there is one.
+	if !claims.VerifyExpiresAt(time.Now().Unix(), true) {
+		return Principal{}, fmt.Errorf("%w: token has no expiry", ErrInvalidToken)
+	}
+	if !claims.VerifyIssuer(v.opts.Issuer, true) {
+		return Principal{}, fmt.Errorf("%w: token has the wrong issuer", ErrInvalidToken)
+	}
+	if !claims.VerifyAudience(v.opts.Audience, true) {
+		return Principal{}, fmt.Errorf("%w: token has the wrong audience", ErrInvalidToken)
+	}
+
+	p := Principal{Id: "user", Name: "user", Scopes: []string{ScopeRead}, Hidden: v.opts.RestrictedNames}
+	if sub, ok := claims["sub"].(string); ok && sub != "" {
+		p.Id = "user:" + sub
+		p.Name = "user " + sub
+	}
+	for _, role := range roles(claims, v.opts.RolesClaim) {
+		if role == v.opts.AdminRole {
+			p.Scopes = []string{ScopeRead, ScopeDecrypt}
+			p.Hidden = nil
+		}
+	}
+
+	return p, nil
+}
+
+// roles reads the list of roles at the path into the claims. A string is taken
+// as a space separated list, as in the scope claim.
+func roles(claims jwt.MapClaims, path string) []string {
+	var value any = map[string]any(claims)
+	for _, field := range strings.Split(path, ".") {
+		object, ok := value.(map[string]any)
+		if !ok {
+			return nil
+		}
+		value = object[field]
+	}
+
+	switch v := value.(type) {
+	case string:
+		return strings.Fields(v)
+	case []any:
+		list := []string{}
+		for _, item := range v {
+			if s, ok := item.(string); ok {
+				list = append(list, s)
+			}
+		}
+		return list
+	}
+	return nil
+}
+
+// key finds the key that signed the token. Once the key set is due to be
+// reloaded it is reloaded in the background while the keys already loaded
+// carry on being used. Tokens signed with a key the set doesn't hold wait for
+// it to be reloaded, at most once a minute.
+func (v *TokenVerifier) key(token *jwt.Token) (any, error) {
+	kid, _ := token.Header["kid"].(string)
+
+	v.mu.Lock()
+	key, ok := v.lookup(kid)
+	since := time.Since(v.fetched)
+	if ok {
+		if since >= jwksTTL {
+			v.reload()
+		}
+		v.mu.Unlock()
+		return key, nil
+	}
+	if since < jwksMinRefresh {
+		v.mu.Unlock()
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	done := v.reload()
+	v.mu.Unlock()
+
+	<-done
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	if key, ok = v.lookup(kid); !ok {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	return key, nil
+}
+
+// reload starts reloading the key set unless that is already under way, and
+// returns a channel that is closed once it is done. v.mu must be held; it is
+// only taken again to swap in the new keys, so that verifying tokens doesn't
+// wait on the identity provider.
+func (v *TokenVerifier) reload() <-chan struct{} {
+	if v.reloading != nil {
+		return v.reloading
+	}
+
+	done := make(chan struct{})
+	v.reloading = done
+
+	go func() {
+		keys, err := v.load()
+
+		v.mu.Lock()
+		defer v.mu.Unlock()
+
+		v.fetched = time.Now()
+		if err != nil {
+			// Carry on with the keys already loaded.
+			log.Println(fmt.Errorf("reloading JWKS: %w", err))
+		} else {
+			v.keys = keys
+		}
+		v.reloading = nil
+		close(done)
+	}()
+
+	return done
+}
+
+// lookup returns the key with the ID, or the only key if the token names none.
+func (v *TokenVerifier) lookup(kid string) (crypto.PublicKey, bool) {
+	if kid == "" && len(v.keys) == 1 {
+		for _, key := range v.keys {
+			return key, true
+		}
+	}
+	key, ok := v.keys[kid]
+	return key, ok
+}
+
+// load reads the key set from its file or URL.
+func (v *TokenVerifier) load() (map[string]crypto.PublicKey, error) {
+	var data []byte
+	var err error
+	if strings.HasPrefix(v.opts.JWKS, "http://") || strings.HasPrefix(v.opts.JWKS, "https://") {
+		data, err = v.fetch()
+	} else {
+		data, err = os.ReadFile(v.opts.JWKS)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return ParseJWKS(data)
+}
+
+func (v *TokenVerifier) fetch() ([]byte, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), jwksTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, v.opts.JWKS, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := v.client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, errors.New(res.Status)
+	}
+	return io.ReadAll(res.Body)
+}
+
+// jwk is a single key of a JWKS, as described by RFC 7517.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+
+	// RSA keys.
+	N string `json:"n"`
+	E string `json:"e"`
+
+	// Elliptic curve keys.
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// ParseJWKS decodes the RSA and elliptic curve signing keys of a JWKS by ID.
+// Keys of other types are skipped.
+func ParseJWKS(data []byte) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("malformed JWKS: %w", err)
+	}
+
+	keys := map[string]crypto.PublicKey{}
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		switch k.Kty {
+		case "RSA":
+			n, err := decodeInt(k.N)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			e, err := decodeInt(k.E)
+			if err != nil || !e.IsInt64() {
+				return nil, fmt.Errorf("key %q: malformed exponent", k.Kid)
+			}
+			keys[k.Kid] = &rsa.PublicKey{N: n, E: int(e.Int64())}
+
+		case "EC":
+			var curve elliptic.Curve
+			switch k.Crv {
+			case "P-256":
+				curve = elliptic.P256()
+			case "P-384":
+				curve = elliptic.P384()
+			case "P-521":
+				curve = elliptic.P521()
+			default:
+				continue
+			}
+			x, err := decodeInt(k.X)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			y, err := decodeInt(k.Y)
+			if err != nil {
+				return nil, fmt.Errorf("key %q: %w", k.Kid, err)
+			}
+			if !curve.IsOnCurve(x, y) {
+				return nil, fmt.Errorf("key %q: point is not on the curve", k.Kid)
+			}
+			keys[k.Kid] = &ecdsa.PublicKey{Curve: curve, X: x, Y: y}
+		}
+	}
+
+	if len(keys) == 0 {
+		return nil, errors.New("JWKS holds no signing keys")
+	}
+	return keys, nil
+}
+
+func decodeInt(s string) (*big.Int, error) {
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil || len(data) == 0 {
+		return nil, errors.New("malformed key parameter")
+	}
+	return new(big.Int).SetBytes(data), nil
+}
@@ -0,0 +1,250 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v4"
+)
+
+func encodeInt(n *big.Int) string {
+	return base64.RawURLEncoding.EncodeToString(n.Bytes())
+}
+
+// newTestVerifier writes a JWKS holding an RSA and an EC key and returns a
+// verifier reading it, along with the private keys.
+func newTestVerifier(t *testing.T) (*TokenVerifier, *rsa.PrivateKey, *ecdsa.PrivateKey) {
+	t.Helper()
+
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	set := map[string]any{
+		"keys": []map[string]string{
+			{
+				"kty": "RSA",
+				"kid": "rsa",
+				"use": "sig",
+				"n":   encodeInt(rsaKey.N),
+				"e":   encodeInt(big.NewInt(int64(rsaKey.E))),
+			},
+			{
+				"kty": "EC",
+				"kid": "ec",
+				"crv": "P-256",
+				"x":   encodeInt(ecKey.X),
+				"y":   encodeInt(ecKey.Y),
+			},
+		},
+	}
+	data, err := json.Marshal(set)
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "jwks.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	v, err := NewTokenVerifier(TokenOptions{
+		JWKS:            path,
+		Issuer:          "https://id.example.com",
+		Audience:        "events",
+		RolesClaim:      "realm_access.roles",
+		AdminRole:       "admin",
+		RestrictedNames: []string{"login", "user.#"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return v, rsaKey, ecKey
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key any, claims jwt.MapClaims) string {
+	t.Helper()
+
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+func claims(changes map[string]any) jwt.MapClaims {
+	c := jwt.MapClaims{
+		"sub": "alice",
+		"iss": "https://id.example.com",
+		"aud": "events",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range changes {
+		if v == nil {
+			delete(c, k)
+		} else {
+			c[k] = v
+		}
+	}
+	return c
+}
+
+func TestVerify(t *testing.T) {
+	v, rsaKey, ecKey := newTestVerifier(t)
+
+	tests := []struct {
+		name  string
+		token string
+		valid bool
+	}{
+		{"valid RSA token", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(nil)), true},
+		{"valid EC token", sign(t, jwt.SigningMethodES256, "ec", ecKey, claims(nil)), true},
+		{"audience in a list", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]any{"aud": []string{"other", "events"}})), true},
+		{"expired", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]any{"exp": time.Now().Add(-time.Minute).Unix()})), false},
+		{"missing exp", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]any{"exp": nil})), false},
+		{"wrong iss", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]any{"iss": "https://evil.example.com"})), false},
+		{"missing iss", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]any{"iss": nil})), false},
+		{"wrong aud", sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(map[string]any{"aud": "other"})), false},
+		{"unknown kid", sign(t, jwt.SigningMethodRS256, "other", rsaKey, claims(nil)), false},
+		{"key of another kid", sign(t, jwt.SigningMethodRS256, "ec", rsaKey, claims(nil)), false},
+		{"HS256 signed with the public key", sign(t, jwt.SigningMethodHS256, "rsa", []byte(encodeInt(rsaKey.N)), claims(nil)), false},
+		{"alg none", sign(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType, claims(nil)), false},
+		{"garbage", "not.a.token", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := v.Verify(test.token)
+			if test.valid {
+				if err != nil {
+					t.Fatalf("expected the token to be accepted, got %v", err)
+				}
+				if p.Id != "user:alice" {
+					t.Errorf("expected principal user:alice, got %q", p.Id)
+				}
+				return
+			}
+			if !errors.Is(err, ErrInvalidToken) {
+				t.Fatalf("expected ErrInvalidToken, got %v", err)
+			}
+		})
+	}
+}
+
+func TestVerifyRoles(t *testing.T) {
+	v, rsaKey, _ := newTestVerifier(t)
+
+	tests := []struct {
+		name    string
+		roles   any
+		admin   bool
+		allowed map[string]bool
+	}{
+		{
+			name:  "no roles",
+			roles: nil,
+			allowed: map[string]bool{
+				"article.scraped": true,
+				"login":           false,
+				"user.invite":     false,
+				"user":            false,
+			},
+		},
+		{
+			name:  "other roles",
+			roles: []string{"viewer"},
+			allowed: map[string]bool{
+				"tweet":             true,
+				"login":             false,
+				"user.solicitation": false,
+			},
+		},
+		{
+			name:  "admin",
+			roles: []string{"viewer", "admin"},
+			admin: true,
+			allowed: map[string]bool{
+				"tweet":       true,
+				"login":       true,
+				"user.invite": true,
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			changes := map[string]any{}
+			if test.roles != nil {
+				changes["realm_access"] = map[string]any{"roles": test.roles}
+			}
+
+			p, err := v.Verify(sign(t, jwt.SigningMethodRS256, "rsa", rsaKey, claims(changes)))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if p.Has(ScopePublish) || p.Has(ScopeAdmin) {
+				t.Errorf("tokens must only grant reads, got %v", p.Scopes)
+			}
+			if p.Has(ScopeDecrypt) != test.admin {
+				t.Errorf("expected decrypt scope %v, got %v", test.admin, p.Scopes)
+			}
+			for name, allowed := range test.allowed {
+				if got := p.Allows(ScopeRead, name); got != allowed {
+					t.Errorf("reading %q: expected %v, got %v", name, allowed, got)
+				}
+			}
+		})
+	}
+}
+
+func TestVerifyReloadsForUnknownKey(t *testing.T) {
+	v, _, _ := newTestVerifier(t)
+
+	// Rotate a new key into the set.
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := json.Marshal(map[string]any{
+		"keys": []map[string]string{{"kty": "RSA", "kid": "rotated", "n": encodeInt(key.N), "e": encodeInt(big.NewInt(int64(key.E)))}},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(v.opts.JWKS, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	token := sign(t, jwt.SigningMethodRS256, "rotated", key, claims(nil))
+
+	// The set was only just loaded, so it isn't reloaded yet.
+	if _, err := v.Verify(token); !errors.Is(err, ErrInvalidToken) {
+		t.Fatalf("expected ErrInvalidToken, got %v", err)
+	}
+
+	v.mu.Lock()
+	v.fetched = time.Now().Add(-jwksMinRefresh)
+	v.mu.Unlock()
+
+	if _, err := v.Verify(token); err != nil {
+		t.Fatalf("expected the rotated key to be loaded, got %v", err)
+	}
+}
@@ -1,6 +1,10 @@
 package config
 
 import (
+	"fmt"
+	"strings"
+	"time"
+
 	"github.com/allokate-ai/environment"
 	"github.com/joho/godotenv"
 )
@@ -20,15 +24,134 @@ type DatabaseConfig struct {
 	Database string
 }
 
+type OutboxConfig struct {
+	Enabled   bool
+	Interval  time.Duration
+	BatchSize int
+}
+
+type IdempotencyConfig struct {
+	Window time.Duration
+}
+
+type ConsumerConfig struct {
+	MaxAttempts  int
+	RetryDelays  []time.Duration
+	BatchSize    int
+	BatchTimeout time.Duration
+}
+
+type RetentionConfig struct {
+	Interval time.Duration
+}
+
+type RollupConfig struct {
+	Interval time.Duration
+	Lookback time.Duration
+}
+
+type EncryptionConfig struct {
+	KeyFile      string
+	FieldsFile   string
+	DecryptToken string
+}
+
+type RateLimitConfig struct {
+	File string
+}
+
+type AuthConfig struct {
+	Enabled bool
+	JWT     JWTConfig
+}
+
+type JWTConfig struct {
+	JWKS            string
+	Issuer          string
+	Audience        string
+	RolesClaim      string
+	AdminRole       string
+	RestrictedNames []string
+}
+
 type Config struct {
-	Port       int
-	AMQPConfig AMQPConfig
-	Database   DatabaseConfig
+	Port        int
+	AMQPConfig  AMQPConfig
+	Database    DatabaseConfig
+	Outbox      OutboxConfig
+	Idempotency IdempotencyConfig
+	Consumer    ConsumerConfig
+	Retention   RetentionConfig
+	Rollup      RollupConfig
+	Encryption  EncryptionConfig
+	Auth        AuthConfig
+	RateLimit   RateLimitConfig
 }
 
 func Get() (Config, error) {
 	godotenv.Load()
 
+	outboxInterval, err := time.ParseDuration(environment.GetValueOrDefault("OUTBOX_INTERVAL", "1s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid OUTBOX_INTERVAL: %w", err)
+	}
+
+	idempotencyWindow, err := time.ParseDuration(environment.GetValueOrDefault("IDEMPOTENCY_WINDOW", "24h"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid IDEMPOTENCY_WINDOW: %w", err)
+	}
+
+	batchTimeout, err := time.ParseDuration(environment.GetValueOrDefault("CONSUMER_BATCH_TIMEOUT", "1s"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid CONSUMER_BATCH_TIMEOUT: %w", err)
+	}
+
+	retentionInterval, err := time.ParseDuration(environment.GetValueOrDefault("RETENTION_INTERVAL", "1h"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid RETENTION_INTERVAL: %w", err)
+	}
+
+	rollupInterval, err := time.ParseDuration(environment.GetValueOrDefault("ROLLUP_INTERVAL", "1m"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid ROLLUP_INTERVAL: %w", err)
+	}
+
+	rollupLookback, err := time.ParseDuration(environment.GetValueOrDefault("ROLLUP_LOOKBACK", "3h"))
+	if err != nil {
+		return Config{}, fmt.Errorf("invalid ROLLUP_LOOKBACK: %w", err)
+	}
+
+	retryDelays := []time.Duration{}
+	for _, v := range strings.Split(environment.GetValueOrDefault("CONSUMER_RETRY_DELAYS", "1s,10s,1m,10m"), ",") {
+		if v = strings.TrimSpace(v); v == "" {
+			continue
+		}
+		delay, err := time.ParseDuration(v)
+		if err != nil {
+			return Config{}, fmt.Errorf("invalid CONSUMER_RETRY_DELAYS: %w", err)
+		}
+		if delay < time.Millisecond {
+			return Config{}, fmt.Errorf("invalid CONSUMER_RETRY_DELAYS: delay %s is shorter than a millisecond", v)
+		}
+		retryDelays = append(retryDelays, delay)
+	}
+
+	jwt := JWTConfig{
+		JWKS:       environment.GetValueOrDefault("JWT_JWKS", ""),
+		Issuer:     environment.GetValueOrDefault("JWT_ISSUER", ""),
+		Audience:   environment.GetValueOrDefault("JWT_AUDIENCE", ""),
+		RolesClaim: environment.GetValueOrDefault("JWT_ROLES_CLAIM", "roles"),
+		AdminRole:  environment.GetValueOrDefault("JWT_ADMIN_ROLE", "admin"),
+	}
+	for _, v := range strings.Split(environment.GetValueOrDefault("JWT_RESTRICTED_NAMES", "login,user.#"), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			jwt.RestrictedNames = append(jwt.RestrictedNames, v)
+		}
+	}
+	if jwt.JWKS != "" && (jwt.Issuer == "" || jwt.Audience == "") {
+		return Config{}, fmt.Errorf("JWT_ISSUER and JWT_AUDIENCE are required with JWT_JWKS")
+	}
+
 	return Config{
 		Port: int(environment.GetIntOrDefault("PORT", 8094)),
 		AMQPConfig: AMQPConfig{
@@ -44,5 +167,38 @@ func Get() (Config, error) {
 			Password: environment.GetValueOrDefault("POSTGRES_PASSWORD", "example"),
 			Database: environment.GetValueOrDefault("POSTGRES_DATABASE", "allokate"),
 		},
+		Outbox: OutboxConfig{
+			Enabled:   environment.GetBoolOrDefault("OUTBOX_ENABLED", false),
+			Interval:  outboxInterval,
+			BatchSize: int(environment.GetIntOrDefault("OUTBOX_BATCH_SIZE", 100)),
+		},
+		Idempotency: IdempotencyConfig{
+			Window: idempotencyWindow,
+		},
+		Consumer: ConsumerConfig{
+			MaxAttempts:  int(environment.GetIntOrDefault("CONSUMER_MAX_ATTEMPTS", 5)),
+			RetryDelays:  retryDelays,
+			BatchSize:    int(environment.GetIntOrDefault("CONSUMER_BATCH_SIZE", 100)),
+			BatchTimeout: batchTimeout,
+		},
+		Retention: RetentionConfig{
+			Interval: retentionInterval,
+		},
+		Rollup: RollupConfig{
+			Interval: rollupInterval,
+			Lookback: rollupLookback,
+		},
+		Encryption: EncryptionConfig{
+			KeyFile:      environment.GetValueOrDefault("ENCRYPTION_KEY_FILE", ""),
+			FieldsFile:   environment.GetValueOrDefault("ENCRYPTION_FIELDS_FILE", ""),
+			DecryptToken: environment.GetValueOrDefault("ENCRYPTION_DECRYPT_TOKEN", ""),
+		},
+		Auth: AuthConfig{
+			Enabled: environment.GetBoolOrDefault("AUTH_ENABLED", false),
+			JWT:     jwt,
+		},
+		RateLimit: RateLimitConfig{
+			File: environment.GetValueOrDefault("RATE_LIMITS_FILE", ""),
+		},
 	}, nil
 }
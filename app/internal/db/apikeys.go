@@ -0,0 +1,102 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// APIKey grants its holder the scopes listed. Keys that publish do so as their
+// source.
+type APIKey struct {
+	Id        string     `json:"id"`
+	Name      string     `json:"name"`
+	Prefix    string     `json:"prefix"`
+	Source    *string    `json:"source"`
+	Scopes    []string   `json:"scopes"`
+	CreatedAt time.Time  `json:"created_at"`
+	RevokedAt *time.Time `json:"revoked_at,omitempty"`
+}
+
+var ErrNoSuchAPIKey = errors.New("no such api key exists")
+
+// CreateAPIKey stores a new API key along with the hash of its secret.
+func CreateAPIKey(db *sql.DB, key APIKey, hash string) (APIKey, error) {
+	err := db.QueryRow(`INSERT INTO api_keys (
+			id,
+			name,
+			prefix,
+			hash,
+			source,
+			scopes
+		) VALUES ($1, $2, $3, $4, $5, $6) RETURNING created_at
+	`, key.Id, key.Name, key.Prefix, hash, key.Source, pq.Array(key.Scopes)).Scan(&key.CreatedAt)
+	return key, err
+}
+
+const selectAPIKeys = `SELECT id, name, prefix, source, scopes, created_at, revoked_at FROM api_keys`
+
+func scanAPIKey(row interface{ Scan(...any) error }) (APIKey, error) {
+	var key APIKey
+	var source sql.NullString
+	var revokedAt sql.NullTime
+	err := row.Scan(&key.Id, &key.Name, &key.Prefix, &source, pq.Array(&key.Scopes), &key.CreatedAt, &revokedAt)
+	if source.Valid {
+		key.Source = &source.String
+	}
+	if revokedAt.Valid {
+		key.RevokedAt = &revokedAt.Time
+	}
+	return key, err
+}
+
+// GetAPIKeyByHash returns the API key whose secret has the given hash, unless
+// it has been revoked.
+func GetAPIKeyByHash(db *sql.DB, hash string) (APIKey, error) {
+	key, err := scanAPIKey(db.QueryRow(selectAPIKeys+` WHERE hash=$1 AND revoked_at IS NULL`, hash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return key, ErrNoSuchAPIKey
+	}
+	return key, err
+}
+
+// ListAPIKeys returns every API key, including revoked ones, newest first.
+func ListAPIKeys(db *sql.DB) ([]APIKey, error) {
+	rows, err := db.Query(selectAPIKeys + ` ORDER BY created_at DESC`)
+	if err != nil {
+		return []APIKey{}, err
+	}
+	defer rows.Close()
+
+	list := []APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, key)
+	}
+
+	return list, rows.Err()
+}
+
+// RevokeAPIKey stops the API key with the given ID from being accepted. The
+// key is kept for the record.
+func RevokeAPIKey(db *sql.DB, id string) error {
+	result, err := db.Exec(`UPDATE api_keys SET revoked_at=CURRENT_TIMESTAMP WHERE id=$1 AND revoked_at IS NULL`, id)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoSuchAPIKey
+	}
+
+	return nil
+}
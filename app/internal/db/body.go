@@ -0,0 +1,145 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/lib/pq"
+)
+
+// Operators accepted by body filters.
+const (
+	BodyEq       = "="
+	BodyNe       = "!="
+	BodyGt       = ">"
+	BodyGte      = ">="
+	BodyLt       = "<"
+	BodyLte      = "<="
+	BodyContains = "[]="
+)
+
+// BodyFilter narrows down events by a field of their body. The field is named
+// by its path from the root of the body.
+type BodyFilter struct {
+	Path  []string `json:"path"`
+	Op    string   `json:"op"`
+	Value any      `json:"value"`
+}
+
+var bodyFilterExpr = regexp.MustCompile(`^body((?:\.[A-Za-z0-9_-]+)+)(\[\]=|!=|>=|<=|=|>|<)(.*)$`)
+
+// ParseBodyFilter parses an expression such as body.ticker=AAPL,
+// body.dividendRate>0.5 or body.tags[]=earnings. Values that read as JSON
+// numbers, booleans or null are taken as such; anything else, or a value in
+// double quotes, is a string.
+func ParseBodyFilter(expr string) (BodyFilter, error) {
+	match := bodyFilterExpr.FindStringSubmatch(expr)
+	if match == nil {
+		return BodyFilter{}, fmt.Errorf("%q must look like body.field=value, with one of the operators =, !=, >, >=, <, <= or []=", expr)
+	}
+
+	filter := BodyFilter{
+		Path: strings.Split(match[1][1:], "."),
+		Op:   match[2],
+	}
+
+	raw := match[3]
+	if strings.HasPrefix(raw, `"`) {
+		var s string
+		if err := json.Unmarshal([]byte(raw), &s); err != nil {
+			return BodyFilter{}, fmt.Errorf("%q has a malformed quoted value", expr)
+		}
+		filter.Value = s
+	} else if err := json.Unmarshal([]byte(raw), &filter.Value); err != nil || raw == "" {
+		filter.Value = raw
+	} else if _, ok := filter.Value.(map[string]any); ok {
+		filter.Value = raw
+	} else if _, ok := filter.Value.([]any); ok {
+		filter.Value = raw
+	}
+
+	switch filter.Op {
+	case BodyGt, BodyGte, BodyLt, BodyLte:
+		switch filter.Value.(type) {
+		case float64, string:
+		default:
+			return BodyFilter{}, fmt.Errorf("%q must compare against a number or a string", expr)
+		}
+	}
+
+	return filter, nil
+}
+
+// nest wraps the value in objects along the path, e.g. {"a": {"b": value}}.
+func nest(path []string, value any) string {
+	for i := len(path) - 1; i >= 0; i-- {
+		value = map[string]any{path[i]: value}
+	}
+	// Values parsed from JSON always marshal.
+	doc, _ := json.Marshal(value)
+	return string(doc)
+}
+
+// where renders the filter as a SQL condition, appending its parameters to
+// args. Equality and containment are expressed with @> so that they can use
+// the GIN index on the body. Filters that ParseBodyFilter wouldn't have
+// produced match nothing.
+func (f BodyFilter) where(args []any) (string, []any) {
+	if len(f.Path) == 0 {
+		return "FALSE", args
+	}
+
+	switch f.Op {
+	case BodyEq, BodyNe:
+		docs := []any{f.Value}
+
+		// A bare number or boolean also matches the same value stored as a
+		// string, since the two can't be told apart in a query string.
+		switch v := f.Value.(type) {
+		case float64:
+			docs = append(docs, strconv.FormatFloat(v, 'f', -1, 64))
+		case bool:
+			docs = append(docs, strconv.FormatBool(v))
+		}
+
+		conditions := []string{}
+		for _, value := range docs {
+			args = append(args, nest(f.Path, value))
+			conditions = append(conditions, fmt.Sprintf("body @> $%d::JSONB", len(args)))
+		}
+
+		condition := "(" + strings.Join(conditions, " OR ") + ")"
+		if f.Op == BodyNe {
+			condition = "NOT " + condition
+		}
+		return condition, args
+
+	case BodyContains:
+		args = append(args, nest(f.Path, []any{f.Value}))
+		return fmt.Sprintf("body @> $%d::JSONB", len(args)), args
+
+	case BodyGt, BodyGte, BodyLt, BodyLte:
+		args = append(args, pq.Array(f.Path))
+		path := len(args)
+
+		switch v := f.Value.(type) {
+		case float64:
+			args = append(args, v)
+			return fmt.Sprintf(
+				"(CASE WHEN jsonb_typeof(body #> $%d::TEXT[]) = 'number' THEN (body #>> $%d::TEXT[])::NUMERIC END) %s $%d::NUMERIC",
+				path, path, f.Op, len(args),
+			), args
+		case string:
+			args = append(args, v)
+			return fmt.Sprintf(
+				"(CASE WHEN jsonb_typeof(body #> $%d::TEXT[]) = 'string' THEN body #>> $%d::TEXT[] END) %s $%d::TEXT",
+				path, path, f.Op, len(args),
+			), args
+		}
+	}
+
+	return "FALSE", args
+}
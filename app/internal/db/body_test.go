@@ -0,0 +1,71 @@
+package db
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseBodyFilter(t *testing.T) {
+	tests := []struct {
+		expr string
+		want BodyFilter
+	}{
+		{"body.ticker=AAPL", BodyFilter{Path: []string{"ticker"}, Op: BodyEq, Value: "AAPL"}},
+		{"body.ticker!=AAPL", BodyFilter{Path: []string{"ticker"}, Op: BodyNe, Value: "AAPL"}},
+		{"body.dividendRate>0.5", BodyFilter{Path: []string{"dividendRate"}, Op: BodyGt, Value: 0.5}},
+		{"body.dividendRate>=1", BodyFilter{Path: []string{"dividendRate"}, Op: BodyGte, Value: 1.0}},
+		{"body.dividendRate<-2", BodyFilter{Path: []string{"dividendRate"}, Op: BodyLt, Value: -2.0}},
+		{"body.dividendRate<=3e2", BodyFilter{Path: []string{"dividendRate"}, Op: BodyLte, Value: 300.0}},
+		{"body.tags[]=earnings", BodyFilter{Path: []string{"tags"}, Op: BodyContains, Value: "earnings"}},
+
+		// Values.
+		{"body.active=true", BodyFilter{Path: []string{"active"}, Op: BodyEq, Value: true}},
+		{"body.deleted=null", BodyFilter{Path: []string{"deleted"}, Op: BodyEq, Value: nil}},
+		{`body.ticker="42"`, BodyFilter{Path: []string{"ticker"}, Op: BodyEq, Value: "42"}},
+		{`body.name="a=b"`, BodyFilter{Path: []string{"name"}, Op: BodyEq, Value: "a=b"}},
+		{"body.name=", BodyFilter{Path: []string{"name"}, Op: BodyEq, Value: ""}},
+		{"body.name=a=b", BodyFilter{Path: []string{"name"}, Op: BodyEq, Value: "a=b"}},
+		{`body.doc={"a":1}`, BodyFilter{Path: []string{"doc"}, Op: BodyEq, Value: `{"a":1}`}},
+		{"body.list=[1,2]", BodyFilter{Path: []string{"list"}, Op: BodyEq, Value: "[1,2]"}},
+		{"body.date>2022-01-01", BodyFilter{Path: []string{"date"}, Op: BodyGt, Value: "2022-01-01"}},
+
+		// Paths.
+		{"body.author.id=7", BodyFilter{Path: []string{"author", "id"}, Op: BodyEq, Value: 7.0}},
+		{"body.a_b.c-d.0=x", BodyFilter{Path: []string{"a_b", "c-d", "0"}, Op: BodyEq, Value: "x"}},
+	}
+
+	for _, test := range tests {
+		got, err := ParseBodyFilter(test.expr)
+		if err != nil {
+			t.Errorf("%s: %v", test.expr, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.want) {
+			t.Errorf("%s: expected %#v, got %#v", test.expr, test.want, got)
+		}
+	}
+}
+
+func TestParseBodyFilterMalformed(t *testing.T) {
+	exprs := []string{
+		"",
+		"body",
+		"body=AAPL",
+		"body.=AAPL",
+		"body..ticker=AAPL",
+		"body.ticker",
+		"body.ticker~AAPL",
+		"ticker=AAPL",
+		"bodyticker=AAPL",
+		"body.tick er=AAPL",
+		`body.ticker="AAPL`,
+		"body.active>true",
+		"body.deleted<null",
+	}
+
+	for _, expr := range exprs {
+		if f, err := ParseBodyFilter(expr); err == nil {
+			t.Errorf("expected %q to be rejected, got %#v", expr, f)
+		}
+	}
+}
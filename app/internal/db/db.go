@@ -2,13 +2,16 @@ package db
 
 import (
 	"database/sql"
+	"encoding/base64"
 	"errors"
 	"fmt"
 	"log"
+	"regexp"
+	"sort"
 	"strings"
 	"time"
 
-	_ "github.com/lib/pq"
+	"github.com/lib/pq"
 
 	events "github.com/allokate-ai/events/app/pkg/client"
 )
@@ -19,43 +22,195 @@ func Connect(host string, port int, user, password, db string) (*sql.DB, error)
 		host, port, user, password, db))
 }
 
+// Init brings the database schema up to date and makes sure the events table
+// has partitions ready for the coming months.
 func Init(db *sql.DB) error {
-	_, err := db.Exec(`
-		CREATE TABLE IF NOT EXISTS events (
-			id VARCHAR NOT NULL PRIMARY KEY,
-			timestamp TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
-			name VARCHAR NOT NULL,
-			source VARCHAR NOT NULL,
-			body JSONB
-		);
-		
-		CREATE INDEX IF NOT EXISTS event_timestamp_idx ON events USING BTREE((timestamp::TIMESTAMP));
-	`)
+	if _, err := MigrateUp(db, 0); err != nil {
+		return err
+	}
 
-	return err
+	now := time.Now()
+	return CreatePartitions(db, now, now.AddDate(0, PartitionsAhead, 0))
 }
 
+const upsertEventQuery = `INSERT INTO events (
+		id,
+		timestamp,
+		name,
+		source,
+		body
+	) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id, timestamp) DO UPDATE SET
+		name=$3,
+		source=$4,
+		body=$5
+`
+
 func UpsertEvent(db *sql.DB, event events.GenericEvent) (events.GenericEvent, error) {
-	rows, err := db.Query(`INSERT INTO events (
-			id,
-			timestamp,
-			name,
-			source,
-			body
-		) VALUES ($1, $2, $3, $4, $5) ON CONFLICT (id) DO UPDATE SET
-			id=$1,
-			timestamp=$2,
-			name=$3,
-			source=$4,
-			body=$5
-	`, event.Id, event.Timestamp, event.Name, event.Source, event.Body)
+	if err := ensurePartitions(db, []events.GenericEvent{event}); err != nil {
+		return event, err
+	}
 
+	tx, err := db.Begin()
 	if err != nil {
 		return event, err
 	}
-	defer rows.Close()
+	defer tx.Rollback()
 
-	return event, nil
+	list, err := claimEventIds(tx, []events.GenericEvent{event})
+	if err != nil {
+		return event, err
+	}
+	if len(list) == 0 {
+		// The event was erased.
+		return event, nil
+	}
+	if _, err := tx.Exec(upsertEventQuery, event.Id, event.Timestamp, event.Name, event.Source, event.Body); err != nil {
+		return event, err
+	}
+
+	return event, tx.Commit()
+}
+
+// claimEventIds keeps event ids unique across partitions before the events
+// are upserted. Events already stored under the same id with a different
+// timestamp are deleted, so that the upsert moves them to their new
+// timestamp. Erased events are left out of the returned list of events to
+// upsert, so that copies of them redelivered from the queues don't bring
+// them back. The ids must be distinct.
+func claimEventIds(tx *sql.Tx, list []events.GenericEvent) ([]events.GenericEvent, error) {
+	if len(list) == 0 {
+		return list, nil
+	}
+
+	// Lock the ids in the same order everywhere so that concurrent writers
+	// can't deadlock.
+	sorted := append([]events.GenericEvent{}, list...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i].Id < sorted[j].Id })
+
+	ids := make([]string, len(sorted))
+	timestamps := make([]string, len(sorted))
+	for i, event := range sorted {
+		ids[i] = event.Id
+		timestamps[i] = string(pq.FormatTimestamp(event.Timestamp))
+	}
+
+	if _, err := tx.Exec(`INSERT INTO event_ids (id, timestamp)
+		SELECT * FROM unnest($1::VARCHAR[], $2::TIMESTAMP[])
+		ON CONFLICT (id) DO NOTHING
+	`, pq.Array(ids), pq.Array(timestamps)); err != nil {
+		return nil, err
+	}
+
+	// Wait for other writers of the same ids, and for erasures of them, so
+	// that what is read below is the latest.
+	if _, err := tx.Exec(`SELECT 1 FROM event_ids WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(ids)); err != nil {
+		return nil, err
+	}
+
+	erased, err := erasedIds(tx, ids)
+	if err != nil {
+		return nil, err
+	}
+	if len(erased) > 0 {
+		kept := []events.GenericEvent{}
+		for _, event := range list {
+			if !erased[event.Id] {
+				kept = append(kept, event)
+			}
+		}
+		list = kept
+
+		ids, timestamps = ids[:0], timestamps[:0]
+		for _, event := range sorted {
+			if !erased[event.Id] {
+				ids = append(ids, event.Id)
+				timestamps = append(timestamps, string(pq.FormatTimestamp(event.Timestamp)))
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM events e
+		USING event_ids i, unnest($1::VARCHAR[], $2::TIMESTAMP[]) AS n(id, timestamp)
+		WHERE i.id = n.id AND i.timestamp <> n.timestamp AND e.id = i.id AND e.timestamp = i.timestamp
+	`, pq.Array(ids), pq.Array(timestamps)); err != nil {
+		return nil, err
+	}
+
+	if _, err := tx.Exec(`UPDATE event_ids i SET timestamp = n.timestamp
+		FROM unnest($1::VARCHAR[], $2::TIMESTAMP[]) AS n(id, timestamp)
+		WHERE i.id = n.id AND i.timestamp <> n.timestamp
+	`, pq.Array(ids), pq.Array(timestamps)); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// Number of rows written by a single statement in UpsertEvents, which keeps
+// the statement well under Postgres' limit of 65535 parameters.
+const upsertChunkSize = 1000
+
+// UpsertEvents stores the events with multi-row upserts inside a single
+// transaction, so that either all of them are stored or none are. When the
+// same event appears more than once the last copy wins.
+func UpsertEvents(db *sql.DB, list []events.GenericEvent) error {
+	// A statement may not upsert the same row twice.
+	index := map[string]int{}
+	unique := []events.GenericEvent{}
+	for _, event := range list {
+		if i, ok := index[event.Id]; ok {
+			unique[i] = event
+			continue
+		}
+		index[event.Id] = len(unique)
+		unique = append(unique, event)
+	}
+
+	if err := ensurePartitions(db, unique); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	unique, err = claimEventIds(tx, unique)
+	if err != nil {
+		return err
+	}
+
+	for start := 0; start < len(unique); start += upsertChunkSize {
+		end := start + upsertChunkSize
+		if end > len(unique) {
+			end = len(unique)
+		}
+
+		values := []string{}
+		args := []any{}
+		for _, event := range unique[start:end] {
+			args = append(args, event.Id, event.Timestamp, event.Name, event.Source, event.Body)
+			n := len(args)
+			values = append(values, fmt.Sprintf("($%d, $%d, $%d, $%d, $%d)", n-4, n-3, n-2, n-1, n))
+		}
+
+		if _, err := tx.Exec(`INSERT INTO events (
+				id,
+				timestamp,
+				name,
+				source,
+				body
+			) VALUES `+strings.Join(values, ", ")+` ON CONFLICT (id, timestamp) DO UPDATE SET
+				name=EXCLUDED.name,
+				source=EXCLUDED.source,
+				body=EXCLUDED.body
+		`, args...); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
 func GetEvent(db *sql.DB, id string) (events.GenericEvent, error) {
@@ -89,43 +244,175 @@ func GetEvent(db *sql.DB, id string) (events.GenericEvent, error) {
 	return event, nil
 }
 
-func ListEvents(db *sql.DB, from *time.Time, to *time.Time, name *string, source *string) ([]events.GenericEvent, error) {
-	query := "SELECT id, timestamp, name, source, body FROM events"
+// EventFilter narrows down the events returned by a query.
+type EventFilter struct {
+	From   *time.Time `json:"from,omitempty"`
+	To     *time.Time `json:"to,omitempty"`
+	Name   *string    `json:"name,omitempty"`
+	Source *string    `json:"source,omitempty"`
 
+	// If set, event names must match this topic pattern.
+	Pattern *string `json:"pattern,omitempty"`
+
+	// If set, event names must also match at least one of these topic patterns.
+	NamePatterns []string `json:"name_patterns,omitempty"`
+
+	// Event names must not match any of these topic patterns.
+	ExcludedNamePatterns []string `json:"excluded_name_patterns,omitempty"`
+
+	// Events must match every one of these filters on their body.
+	Body []BodyFilter `json:"body,omitempty"`
+}
+
+// where renders the filter as a SQL WHERE clause, appending its parameters to
+// args so that callers may add their own placeholders before or after.
+func (f EventFilter) where(args []any) (string, []any) {
 	filters := []string{}
-	args := []any{}
 
-	if from != nil {
-		filters = append(filters, fmt.Sprintf("timestamp >= $%d", len(filters)+1))
-		args = append(args, from)
+	if f.From != nil {
+		args = append(args, f.From)
+		filters = append(filters, fmt.Sprintf("timestamp >= $%d", len(args)))
+	}
+
+	if f.To != nil {
+		args = append(args, f.To)
+		filters = append(filters, fmt.Sprintf("timestamp < $%d", len(args)))
 	}
 
-	if to != nil {
-		filters = append(filters, fmt.Sprintf("timestamp < $%d", len(filters)+1))
-		args = append(args, to)
+	if f.Name != nil {
+		args = append(args, f.Name)
+		filters = append(filters, fmt.Sprintf("name = $%d", len(args)))
 	}
 
-	if name != nil {
-		filters = append(filters, fmt.Sprintf("name = $%d", len(filters)+1))
-		args = append(args, name)
+	if f.Source != nil {
+		args = append(args, f.Source)
+		filters = append(filters, fmt.Sprintf("source = $%d", len(args)))
 	}
 
-	if source != nil {
-		filters = append(filters, fmt.Sprintf("source = $%d", len(filters)+1))
-		args = append(args, source)
+	if f.Pattern != nil {
+		args = append(args, topicRegexp(*f.Pattern))
+		filters = append(filters, fmt.Sprintf("'.' || name ~ $%d", len(args)))
 	}
 
-	if len(filters) > 0 {
-		query += " WHERE " + strings.Join(filters, " AND ")
+	if len(f.NamePatterns) > 0 {
+		patterns := []string{}
+		for _, pattern := range f.NamePatterns {
+			args = append(args, topicRegexp(pattern))
+			patterns = append(patterns, fmt.Sprintf("'.' || name ~ $%d", len(args)))
+		}
+		filters = append(filters, "("+strings.Join(patterns, " OR ")+")")
 	}
 
-	query += " ORDER BY timestamp DESC"
+	for _, pattern := range f.ExcludedNamePatterns {
+		args = append(args, topicRegexp(pattern))
+		filters = append(filters, fmt.Sprintf("'.' || name !~ $%d", len(args)))
+	}
+
+	for _, body := range f.Body {
+		var condition string
+		condition, args = body.where(args)
+		filters = append(filters, condition)
+	}
+
+	if len(filters) == 0 {
+		return "", args
+	}
+	return " WHERE " + strings.Join(filters, " AND "), args
+}
+
+// topicRegexp translates a topic pattern, where "*" matches a single word and
+// "#" matches zero or more words, into a regular expression matching the
+// routing key prefixed with a dot. The leading dot lets every word, including
+// the first, be matched as a dot followed by the word.
+func topicRegexp(pattern string) string {
+	expr := "^"
+	for _, word := range strings.Split(pattern, ".") {
+		switch word {
+		case "#":
+			expr += `(\.[^.]+)*`
+		case "*":
+			expr += `\.[^.]+`
+		default:
+			expr += `\.` + regexp.QuoteMeta(word)
+		}
+	}
+	return expr + "$"
+}
+
+// Cursor marks a position within the (timestamp DESC, id DESC) ordering of the
+// events table.
+type Cursor struct {
+	Timestamp time.Time
+	Id        string
+}
+
+// String encodes the cursor as an opaque, URL-safe token.
+func (c Cursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(c.Timestamp.Format(time.RFC3339Nano) + "|" + c.Id))
+}
+
+// ParseCursor decodes a token previously produced by Cursor.String.
+func ParseCursor(token string) (Cursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return Cursor{}, errors.New("malformed cursor")
+	}
+
+	parts := strings.SplitN(string(data), "|", 2)
+	if len(parts) != 2 {
+		return Cursor{}, errors.New("malformed cursor")
+	}
+
+	timestamp, err := time.Parse(time.RFC3339Nano, parts[0])
+	if err != nil {
+		return Cursor{}, errors.New("malformed cursor")
+	}
+
+	return Cursor{Timestamp: timestamp, Id: parts[1]}, nil
+}
+
+// ListEvents returns at most limit events matching the filter, newest first,
+// starting after the given cursor. The returned cursor is nil once there are
+// no more events to fetch.
+func ListEvents(db *sql.DB, filter EventFilter, limit int, after *Cursor) ([]events.GenericEvent, *Cursor, error) {
+	return listEvents(db, filter, limit, after, false)
+}
+
+// ListEventsOldestFirst is like ListEvents but walks through the events in
+// chronological order.
+func ListEventsOldestFirst(db *sql.DB, filter EventFilter, limit int, after *Cursor) ([]events.GenericEvent, *Cursor, error) {
+	return listEvents(db, filter, limit, after, true)
+}
+
+func listEvents(db *sql.DB, filter EventFilter, limit int, after *Cursor, ascending bool) ([]events.GenericEvent, *Cursor, error) {
+	query := "SELECT id, timestamp, name, source, body FROM events"
+
+	comparison, order := "<", "DESC"
+	if ascending {
+		comparison, order = ">", "ASC"
+	}
+
+	where, args := filter.where([]any{})
+	if after != nil {
+		args = append(args, after.Timestamp, after.Id)
+		clause := fmt.Sprintf("(timestamp, id) %s ($%d, $%d)", comparison, len(args)-1, len(args))
+		if where == "" {
+			where = " WHERE " + clause
+		} else {
+			where += " AND " + clause
+		}
+	}
+	query += where
+
+	// Fetch one extra row to find out whether there is another page.
+	args = append(args, limit+1)
+	query += fmt.Sprintf(" ORDER BY timestamp %s, id %s LIMIT $%d", order, order, len(args))
 
 	log.Println(query, args)
 	rows, err := db.Query(query, args...)
 
 	if err != nil {
-		return []events.GenericEvent{}, err
+		return []events.GenericEvent{}, nil, err
 	}
 	defer rows.Close()
 
@@ -134,11 +421,30 @@ func ListEvents(db *sql.DB, from *time.Time, to *time.Time, name *string, source
 		var event events.GenericEvent
 		err = rows.Scan(&event.Id, &event.Timestamp, &event.Name, &event.Source, &event.Body)
 		if err != nil {
-			return list, err
+			return list, nil, err
 		}
 		list = append(list, event)
 	}
-	return list, nil
+	if err := rows.Err(); err != nil {
+		return list, nil, err
+	}
+
+	if len(list) <= limit {
+		return list, nil, nil
+	}
+
+	list = list[:limit]
+	last := list[len(list)-1]
+	return list, &Cursor{Timestamp: last.Timestamp, Id: last.Id}, nil
+}
+
+// CountEvents returns the number of events matching the filter.
+func CountEvents(db *sql.DB, filter EventFilter) (int64, error) {
+	where, args := filter.where([]any{})
+
+	var count int64
+	err := db.QueryRow("SELECT COUNT(*) FROM events"+where, args...).Scan(&count)
+	return count, err
 }
 
 func ListNames(db *sql.DB) ([]string, error) {
@@ -0,0 +1,50 @@
+package db
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+)
+
+func TestParseCursor(t *testing.T) {
+	cursors := []Cursor{
+		{Timestamp: time.Date(2022, 3, 4, 5, 6, 7, 123456789, time.UTC), Id: "b3f1c2a0-1d2e-4f5a-8b9c-0d1e2f3a4b5c"},
+		{Timestamp: time.Date(2022, 3, 4, 5, 6, 7, 0, time.FixedZone("EST", -5*60*60)), Id: "plain"},
+		{Timestamp: time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC), Id: "with|pipe"},
+		{Timestamp: time.Date(2022, 3, 4, 5, 6, 7, 0, time.UTC), Id: ""},
+	}
+
+	for _, c := range cursors {
+		got, err := ParseCursor(c.String())
+		if err != nil {
+			t.Errorf("parsing %+v: %v", c, err)
+			continue
+		}
+		if !got.Timestamp.Equal(c.Timestamp) || got.Id != c.Id {
+			t.Errorf("expected %+v, got %+v", c, got)
+		}
+	}
+}
+
+func TestParseCursorMalformed(t *testing.T) {
+	encode := func(s string) string {
+		return base64.RawURLEncoding.EncodeToString([]byte(s))
+	}
+
+	tokens := map[string]string{
+		"empty":             "",
+		"not base64":        "not a cursor!",
+		"padded base64":     base64.URLEncoding.EncodeToString([]byte("2022-03-04T05:06:07Z|id")),
+		"standard base64":   base64.RawStdEncoding.EncodeToString([]byte("2022-03-04T05:06:07Z|id?>")),
+		"no separator":      encode("2022-03-04T05:06:07Z"),
+		"bad timestamp":     encode("yesterday|id"),
+		"date only":         encode("2022-03-04|id"),
+		"missing timestamp": encode("|id"),
+	}
+
+	for name, token := range tokens {
+		if _, err := ParseCursor(token); err == nil {
+			t.Errorf("%s: expected %q to be rejected", name, token)
+		}
+	}
+}
@@ -0,0 +1,263 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/lib/pq"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// Ways of erasing events.
+const (
+	ErasureDelete = "delete"
+	ErasureRedact = "redact"
+)
+
+// Erasure records that events were deleted or redacted.
+type Erasure struct {
+	Id          string    `json:"id"`
+	Mode        string    `json:"mode"`
+	SubjectHash *string   `json:"subject_hash,omitempty"`
+	Reason      string    `json:"reason,omitempty"`
+	EventIds    []string  `json:"event_ids"`
+	CreatedAt   time.Time `json:"created_at"`
+}
+
+var (
+	ErrNoSuchErasure = errors.New("no such erasure exists")
+	ErrNoSuchEvent   = errors.New("no such event exists")
+)
+
+// mentions evaluates to true when any string within the JSON document equals
+// the subject in $1, ignoring case as redaction does, or when the document's
+// body was encrypted with the subject among the fields behind the blind index
+// in $2.
+func mentions(doc, body string) string {
+	return fmt.Sprintf(
+		`(EXISTS (SELECT 1 FROM jsonb_path_query(%s, 'strict $.**') v WHERE jsonb_typeof(v) = 'string' AND lower(v #>> '{}') = lower($1::TEXT)) OR %s->'$envelope'->'idx' ? $2::TEXT)`,
+		doc, body,
+	)
+}
+
+// EraseSubject deletes or redacts every stored event whose body mentions the
+// subject, such as an email address or a user ID, and records the erasure.
+// Events whose personal fields are encrypted are matched by the blind index of
+// the subject, if given. Redacted bodies are worked out by the redact function.
+// Idempotency keys and outbox entries holding copies of the events are removed
+// as well, and the events are recorded as erased so that copies redelivered
+// from the queues aren't stored again. The affected events are returned as
+// they were before the erasure.
+func EraseSubject(db *sql.DB, erasure Erasure, subject, index string, redact func(events.GenericEvent) (json.RawMessage, error)) (Erasure, []events.GenericEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return erasure, nil, err
+	}
+	defer tx.Rollback()
+
+	query := `SELECT id, timestamp, name, source, body FROM events WHERE ` + mentions("body", "body") + ` FOR UPDATE`
+	if erasure.Mode == ErasureDelete {
+		query = `DELETE FROM events WHERE ` + mentions("body", "body") + ` RETURNING id, timestamp, name, source, body`
+	}
+
+	list, err := scanEvents(tx.Query(query, subject, index))
+	if err != nil {
+		return erasure, nil, err
+	}
+
+	if erasure.Mode == ErasureRedact {
+		for _, event := range list {
+			body, err := redact(event)
+			if err != nil {
+				return erasure, nil, err
+			}
+			if _, err := tx.Exec(`UPDATE events SET body=$1 WHERE id=$2 AND timestamp=$3`, body, event.Id, event.Timestamp); err != nil {
+				return erasure, nil, err
+			}
+		}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE `+mentions("event", "event->'body'"), subject, index); err != nil {
+		return erasure, nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE `+mentions("payload", "payload->'body'"), subject, index); err != nil {
+		return erasure, nil, err
+	}
+
+	erasure, err = insertErasure(tx, erasure, list)
+	if err != nil {
+		return erasure, nil, err
+	}
+
+	return erasure, list, tx.Commit()
+}
+
+// DeleteEvent deletes the event with the given ID, along with its idempotency
+// key and outbox entries, and records the erasure so that the event isn't
+// stored again. The deleted event is returned.
+func DeleteEvent(db *sql.DB, erasure Erasure, id string) (Erasure, []events.GenericEvent, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return erasure, nil, err
+	}
+	defer tx.Rollback()
+
+	list, err := scanEvents(tx.Query(`DELETE FROM events WHERE id=$1 RETURNING id, timestamp, name, source, body`, id))
+	if err != nil {
+		return erasure, nil, err
+	}
+	if len(list) == 0 {
+		return erasure, nil, ErrNoSuchEvent
+	}
+	if _, err := tx.Exec(`DELETE FROM idempotency_keys WHERE event->>'id' = $1`, id); err != nil {
+		return erasure, nil, err
+	}
+
+	if _, err := tx.Exec(`DELETE FROM outbox WHERE event_id=$1`, id); err != nil {
+		return erasure, nil, err
+	}
+
+	erasure, err = insertErasure(tx, erasure, list)
+	if err != nil {
+		return erasure, nil, err
+	}
+
+	return erasure, list, tx.Commit()
+}
+
+func scanEvents(rows *sql.Rows, err error) ([]events.GenericEvent, error) {
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []events.GenericEvent{}
+	for rows.Next() {
+		var event events.GenericEvent
+		if err := rows.Scan(&event.Id, &event.Timestamp, &event.Name, &event.Source, &event.Body); err != nil {
+			return list, err
+		}
+		list = append(list, event)
+	}
+
+	return list, rows.Err()
+}
+
+func insertErasure(tx *sql.Tx, erasure Erasure, list []events.GenericEvent) (Erasure, error) {
+	seen := map[string]bool{}
+	erasure.EventIds = []string{}
+	for _, event := range list {
+		if !seen[event.Id] {
+			seen[event.Id] = true
+			erasure.EventIds = append(erasure.EventIds, event.Id)
+		}
+	}
+
+	err := tx.QueryRow(`INSERT INTO erasures (
+			id,
+			mode,
+			subject_hash,
+			reason,
+			event_ids
+		) VALUES ($1, $2, $3, $4, $5) RETURNING created_at
+	`, erasure.Id, erasure.Mode, erasure.SubjectHash, erasure.Reason, pq.Array(erasure.EventIds)).Scan(&erasure.CreatedAt)
+	if err != nil {
+		return erasure, err
+	}
+
+	return erasure, markErased(tx, erasure)
+}
+
+const selectErasures = `SELECT id, mode, subject_hash, COALESCE(reason, ''), event_ids, created_at FROM erasures`
+
+func scanErasure(row interface{ Scan(...any) error }) (Erasure, error) {
+	var erasure Erasure
+	var subjectHash sql.NullString
+	err := row.Scan(&erasure.Id, &erasure.Mode, &subjectHash, &erasure.Reason, pq.Array(&erasure.EventIds), &erasure.CreatedAt)
+	if subjectHash.Valid {
+		erasure.SubjectHash = &subjectHash.String
+	}
+	return erasure, err
+}
+
+// GetErasure returns the erasure with the given ID.
+func GetErasure(db *sql.DB, id string) (Erasure, error) {
+	erasure, err := scanErasure(db.QueryRow(selectErasures+` WHERE id=$1`, id))
+	if errors.Is(err, sql.ErrNoRows) {
+		return erasure, ErrNoSuchErasure
+	}
+	return erasure, err
+}
+
+// ListErasures returns the most recent erasures, newest first.
+func ListErasures(db *sql.DB, limit int) ([]Erasure, error) {
+	rows, err := db.Query(selectErasures+` ORDER BY created_at DESC LIMIT $1`, limit)
+	if err != nil {
+		return []Erasure{}, err
+	}
+	defer rows.Close()
+
+	list := []Erasure{}
+	for rows.Next() {
+		erasure, err := scanErasure(rows)
+		if err != nil {
+			return list, err
+		}
+		list = append(list, erasure)
+	}
+
+	return list, rows.Err()
+}
+
+// markErased records the ids of the erased events so that they are never
+// stored again. The ids are locked first, as writers do, so that an event
+// being stored at the same time either is erased by the end or sees the
+// record and skips it. Copies of deleted events stored in the meantime are
+// deleted too.
+func markErased(tx *sql.Tx, erasure Erasure) error {
+	ids := append([]string{}, erasure.EventIds...)
+	sort.Strings(ids)
+
+	if _, err := tx.Exec(`SELECT 1 FROM event_ids WHERE id = ANY($1) ORDER BY id FOR UPDATE`, pq.Array(ids)); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO erased_events (id, erasure_id)
+		SELECT unnest($1::VARCHAR[]), $2
+		ON CONFLICT (id) DO UPDATE SET erasure_id=EXCLUDED.erasure_id
+	`, pq.Array(ids), erasure.Id); err != nil {
+		return err
+	}
+
+	if erasure.Mode == ErasureDelete {
+		if _, err := tx.Exec(`DELETE FROM events WHERE id = ANY($1)`, pq.Array(ids)); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// erasedIds returns which of the ids belong to erased events.
+func erasedIds(tx *sql.Tx, ids []string) (map[string]bool, error) {
+	rows, err := tx.Query(`SELECT id FROM erased_events WHERE id = ANY($1)`, pq.Array(ids))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	erased := map[string]bool{}
+	for rows.Next() {
+		var id string
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		erased[id] = true
+	}
+	return erased, rows.Err()
+}
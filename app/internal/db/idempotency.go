@@ -0,0 +1,107 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/lib/pq"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// ErrIdempotencyKeyPending is returned when claiming a key that was claimed
+// for an event which hasn't been published yet.
+var ErrIdempotencyKeyPending = errors.New("idempotency key is pending")
+
+// ErrIdempotencyKeyReused is returned when claiming a key that was used for a
+// different request.
+var ErrIdempotencyKeyReused = errors.New("idempotency key was used for a different request")
+
+// Pending keys older than this are taken to have been abandoned, for instance
+// by a server that crashed, and may be claimed again.
+const idempotencyPendingTimeout = time.Minute
+
+// ClaimIdempotencyKey records the event under the key as pending, along with
+// the hash of the request it was sent in, unless the key was already used
+// within the window. If the event the key was used for back then has been
+// published it is returned and claimed is false. If it is still being
+// published ErrIdempotencyKeyPending is returned, and if the key was used for
+// a request with a different hash ErrIdempotencyKeyReused. Claimed keys must
+// be completed or released once the event has been published or not.
+func ClaimIdempotencyKey(db *sql.DB, key string, hash string, event events.GenericEvent, window time.Duration) (original events.GenericEvent, claimed bool, err error) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		return event, false, err
+	}
+
+	err = db.QueryRow(`INSERT INTO idempotency_keys (
+			key,
+			event,
+			request_hash,
+			status,
+			created_at
+		) VALUES ($1, $2, $5, 'pending', CURRENT_TIMESTAMP) ON CONFLICT (key) DO UPDATE SET
+			event=EXCLUDED.event,
+			request_hash=EXCLUDED.request_hash,
+			status=EXCLUDED.status,
+			created_at=EXCLUDED.created_at
+		WHERE
+			idempotency_keys.created_at < CURRENT_TIMESTAMP - make_interval(secs => $3) OR
+			(idempotency_keys.status = 'pending' AND idempotency_keys.created_at < CURRENT_TIMESTAMP - make_interval(secs => $4))
+		RETURNING key
+	`, key, payload, window.Seconds(), idempotencyPendingTimeout.Seconds(), hash).Scan(&key)
+
+	if err == nil {
+		return event, true, nil
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return event, false, err
+	}
+
+	// The key is still live, so hand back the event it was first used for
+	// once that has been published.
+	var status, claimedHash string
+	if err := db.QueryRow(`SELECT event, request_hash, status FROM idempotency_keys WHERE key = $1`, key).Scan(&payload, &claimedHash, &status); err != nil {
+		return event, false, err
+	}
+	if claimedHash != "" && claimedHash != hash {
+		return event, false, ErrIdempotencyKeyReused
+	}
+	if status == "pending" {
+		return event, false, ErrIdempotencyKeyPending
+	}
+	if err := json.Unmarshal(payload, &original); err != nil {
+		return event, false, err
+	}
+
+	return original, false, nil
+}
+
+// CompleteIdempotencyKeys marks the keys as used by events that have been
+// published, so that retries get the events back.
+func CompleteIdempotencyKeys(db *sql.DB, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := db.Exec(`UPDATE idempotency_keys SET status = 'completed' WHERE key = ANY($1)`, pq.Array(keys))
+	return err
+}
+
+// ReleaseIdempotencyKeys forgets the keys so that they may be claimed again,
+// for instance because the events they were claimed for could not be queued.
+func ReleaseIdempotencyKeys(db *sql.DB, keys []string) error {
+	if len(keys) == 0 {
+		return nil
+	}
+	_, err := db.Exec(`DELETE FROM idempotency_keys WHERE key = ANY($1)`, pq.Array(keys))
+	return err
+}
+
+// PurgeIdempotencyKeys removes keys that were claimed longer ago than the
+// window.
+func PurgeIdempotencyKeys(db *sql.DB, window time.Duration) error {
+	_, err := db.Exec(`DELETE FROM idempotency_keys WHERE created_at < CURRENT_TIMESTAMP - make_interval(secs => $1)`, window.Seconds())
+	return err
+}
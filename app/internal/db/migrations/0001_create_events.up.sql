@@ -0,0 +1,53 @@
+-- Set aside an events table from before it was partitioned so that its rows
+-- can be moved over below.
+DO $$
+BEGIN
+	IF (SELECT relkind FROM pg_class WHERE oid = to_regclass('events')) = 'r' THEN
+		ALTER TABLE events RENAME TO events_unpartitioned;
+		ALTER INDEX IF EXISTS events_pkey RENAME TO events_unpartitioned_pkey;
+		ALTER INDEX IF EXISTS event_timestamp_idx RENAME TO events_unpartitioned_timestamp_idx;
+		ALTER INDEX IF EXISTS event_timestamp_id_idx RENAME TO events_unpartitioned_timestamp_id_idx;
+	END IF;
+END $$;
+
+CREATE TABLE IF NOT EXISTS events (
+	id VARCHAR NOT NULL,
+	timestamp TIMESTAMP NOT NULL DEFAULT CURRENT_TIMESTAMP,
+	name VARCHAR NOT NULL,
+	source VARCHAR NOT NULL,
+	body JSONB,
+	PRIMARY KEY (id, timestamp)
+) PARTITION BY RANGE (timestamp);
+
+CREATE INDEX IF NOT EXISTS event_timestamp_idx ON events USING BTREE((timestamp::TIMESTAMP));
+CREATE INDEX IF NOT EXISTS event_timestamp_id_idx ON events USING BTREE(timestamp DESC, id DESC);
+
+-- Move the events of the old table into monthly partitions. Events without a
+-- timestamp are given the current time.
+DO $$
+DECLARE
+	m TIMESTAMP;
+	last TIMESTAMP;
+BEGIN
+	IF to_regclass('events_unpartitioned') IS NOT NULL THEN
+		SELECT
+			date_trunc('month', LEAST(MIN(timestamp), LOCALTIMESTAMP)),
+			date_trunc('month', GREATEST(MAX(timestamp), LOCALTIMESTAMP))
+		INTO m, last
+		FROM events_unpartitioned;
+
+		WHILE m <= last LOOP
+			EXECUTE format(
+				'CREATE TABLE IF NOT EXISTS %I PARTITION OF events FOR VALUES FROM (%L) TO (%L)',
+				'events_p' || to_char(m, 'YYYY_MM'), m, m + INTERVAL '1 month'
+			);
+			m := m + INTERVAL '1 month';
+		END LOOP;
+
+		INSERT INTO events (id, timestamp, name, source, body)
+			SELECT id, COALESCE(timestamp, LOCALTIMESTAMP), name, source, body FROM events_unpartitioned
+			ON CONFLICT DO NOTHING;
+
+		DROP TABLE events_unpartitioned;
+	END IF;
+END $$;
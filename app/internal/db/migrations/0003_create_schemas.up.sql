@@ -0,0 +1,7 @@
+CREATE TABLE IF NOT EXISTS schemas (
+	name VARCHAR NOT NULL,
+	version INTEGER NOT NULL,
+	schema JSONB NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	PRIMARY KEY (name, version)
+);
@@ -0,0 +1,14 @@
+CREATE TABLE IF NOT EXISTS replays (
+	id VARCHAR NOT NULL PRIMARY KEY,
+	status VARCHAR NOT NULL,
+	filter JSONB NOT NULL,
+	exchange VARCHAR NOT NULL,
+	routing_key VARCHAR,
+	rate INTEGER NOT NULL DEFAULT 0,
+	total BIGINT NOT NULL DEFAULT 0,
+	published BIGINT NOT NULL DEFAULT 0,
+	cursor VARCHAR,
+	error VARCHAR,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,5 @@
+CREATE TABLE IF NOT EXISTS idempotency_keys (
+	key VARCHAR NOT NULL PRIMARY KEY,
+	event JSONB NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
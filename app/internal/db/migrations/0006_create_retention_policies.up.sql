@@ -0,0 +1,7 @@
+-- Events are kept forever when keep_days is NULL.
+CREATE TABLE IF NOT EXISTS retention_policies (
+	name VARCHAR NOT NULL PRIMARY KEY,
+	keep_days INTEGER,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
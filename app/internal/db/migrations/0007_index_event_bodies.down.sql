@@ -0,0 +1 @@
+DROP INDEX IF EXISTS event_body_idx;
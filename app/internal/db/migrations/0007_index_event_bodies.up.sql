@@ -0,0 +1,2 @@
+-- Backs the @> containment queries used to filter events by body fields.
+CREATE INDEX IF NOT EXISTS event_body_idx ON events USING GIN(body jsonb_path_ops);
@@ -0,0 +1,10 @@
+-- Full-text search over the text fields of event bodies, such as the title,
+-- excerpt and content of articles and the content of tweets. Titles weigh
+-- the most. Adding the column rewrites every partition.
+ALTER TABLE events ADD COLUMN IF NOT EXISTS search TSVECTOR GENERATED ALWAYS AS (
+	setweight(to_tsvector('english', COALESCE(body->>'title', '')), 'A') ||
+	setweight(to_tsvector('english', COALESCE(body->>'excerpt', '')), 'B') ||
+	setweight(to_tsvector('english', COALESCE(body->>'content', '')), 'C')
+) STORED;
+
+CREATE INDEX IF NOT EXISTS event_search_idx ON events USING GIN(search);
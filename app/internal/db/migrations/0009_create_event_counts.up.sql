@@ -0,0 +1,9 @@
+-- Hourly counts of events by name and source, refreshed by the logger, that
+-- serve stats over long ranges.
+CREATE TABLE IF NOT EXISTS event_counts_hourly (
+	timestamp TIMESTAMP NOT NULL,
+	name VARCHAR NOT NULL,
+	source VARCHAR NOT NULL,
+	count BIGINT NOT NULL,
+	PRIMARY KEY (timestamp, name, source)
+);
@@ -0,0 +1,10 @@
+-- Audit trail of deleted and redacted events. The subject of an erasure is
+-- only kept as a hash so that the trail holds no personal data itself.
+CREATE TABLE IF NOT EXISTS erasures (
+	id VARCHAR NOT NULL PRIMARY KEY,
+	mode VARCHAR NOT NULL,
+	subject_hash VARCHAR,
+	reason VARCHAR,
+	event_ids TEXT[] NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
@@ -0,0 +1,12 @@
+-- Keys are only stored as a SHA-256 hash; the prefix helps people tell them
+-- apart without revealing the rest.
+CREATE TABLE IF NOT EXISTS api_keys (
+	id VARCHAR NOT NULL PRIMARY KEY,
+	name VARCHAR NOT NULL,
+	prefix VARCHAR NOT NULL,
+	hash VARCHAR NOT NULL UNIQUE,
+	source VARCHAR,
+	scopes TEXT[] NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+	revoked_at TIMESTAMP
+);
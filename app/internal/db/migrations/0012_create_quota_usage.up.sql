@@ -0,0 +1,9 @@
+-- Number of events published per UTC day by each subject, an API key or a
+-- source, for each event name.
+CREATE TABLE IF NOT EXISTS quota_usage (
+	day DATE NOT NULL,
+	subject VARCHAR NOT NULL,
+	name VARCHAR NOT NULL,
+	count BIGINT NOT NULL,
+	PRIMARY KEY (day, subject, name)
+);
@@ -0,0 +1,4 @@
+-- Keys are claimed as pending before the event is published and completed
+-- once it has been, so that retries arriving in between aren't told that an
+-- event which may still fail was accepted.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS status VARCHAR NOT NULL DEFAULT 'completed';
@@ -0,0 +1,4 @@
+-- Hash of the request a key was claimed by, so that a key reused for a
+-- different request can be told apart from a retry. Keys claimed before the
+-- hash was recorded have none.
+ALTER TABLE idempotency_keys ADD COLUMN IF NOT EXISTS request_hash VARCHAR NOT NULL DEFAULT '';
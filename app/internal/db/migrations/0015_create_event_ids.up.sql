@@ -0,0 +1,15 @@
+-- The partitioned events table can only be unique on its partition key along
+-- with the id, so the timestamp each id is stored under is kept here to keep
+-- ids unique across partitions.
+CREATE TABLE IF NOT EXISTS event_ids (
+	id VARCHAR NOT NULL PRIMARY KEY,
+	timestamp TIMESTAMP NOT NULL
+);
+
+-- Keep the latest copy of events stored more than once under different
+-- timestamps.
+INSERT INTO event_ids (id, timestamp)
+	SELECT DISTINCT ON (id) id, timestamp FROM events ORDER BY id, timestamp DESC
+	ON CONFLICT DO NOTHING;
+
+DELETE FROM events e USING event_ids i WHERE e.id = i.id AND e.timestamp <> i.timestamp;
@@ -0,0 +1,2 @@
+ALTER TABLE replays DROP COLUMN IF EXISTS lease_expires;
+ALTER TABLE replays DROP COLUMN IF EXISTS owner;
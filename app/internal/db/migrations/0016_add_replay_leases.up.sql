@@ -0,0 +1,5 @@
+-- Replays are run by the server instance holding their lease, which it renews
+-- while it runs them. Replays whose lease has run out are picked up by another
+-- instance.
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS owner VARCHAR;
+ALTER TABLE replays ADD COLUMN IF NOT EXISTS lease_expires TIMESTAMP;
@@ -0,0 +1,14 @@
+-- Ids of deleted and redacted events, so that copies of them redelivered from
+-- the queues are never stored again.
+CREATE TABLE IF NOT EXISTS erased_events (
+	id VARCHAR NOT NULL PRIMARY KEY,
+	erasure_id VARCHAR NOT NULL,
+	created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+-- Events erased so far.
+INSERT INTO erased_events (id, erasure_id)
+	SELECT DISTINCT ON (id) id, erasure_id FROM (
+		SELECT unnest(event_ids) AS id, id AS erasure_id, created_at FROM erasures
+	) e ORDER BY id, created_at DESC
+	ON CONFLICT DO NOTHING;
@@ -0,0 +1,125 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/lib/pq"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// InsertEventsWithOutbox stores the events and queues them for publishing
+// within a single transaction so that an accepted event can never be lost
+// between the database and the message broker.
+func InsertEventsWithOutbox(db *sql.DB, list []events.GenericEvent) error {
+	if err := ensurePartitions(db, list); err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	list, err = claimEventIds(tx, list)
+	if err != nil {
+		return err
+	}
+
+	for _, event := range list {
+		payload, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(upsertEventQuery, event.Id, event.Timestamp, event.Name, event.Source, event.Body); err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(`INSERT INTO outbox (event_id, payload) VALUES ($1, $2)`, event.Id, payload); err != nil {
+			return err
+		}
+	}
+
+	return tx.Commit()
+}
+
+// RelayOutbox hands up to limit pending outbox entries, oldest first, to the
+// publish function and marks those that were published as sent. Rows are
+// locked for the duration so that several relays may run side by side. It
+// returns the number of entries that were sent.
+func RelayOutbox(db *sql.DB, limit int, publish func(events.GenericEvent) error) (int, error) {
+	tx, err := db.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	rows, err := tx.Query(`SELECT
+			id,
+			payload
+		FROM outbox
+		WHERE
+			sent_at IS NULL
+		ORDER BY id
+		LIMIT $1
+		FOR UPDATE SKIP LOCKED
+	`, limit)
+	if err != nil {
+		return 0, err
+	}
+
+	type entry struct {
+		id    int64
+		event events.GenericEvent
+	}
+
+	pending := []entry{}
+	for rows.Next() {
+		var e entry
+		var payload []byte
+		if err := rows.Scan(&e.id, &payload); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		if err := json.Unmarshal(payload, &e.event); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		pending = append(pending, e)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	// Publish in order and stop at the first failure so that the remaining
+	// entries are retried, in order, on the next pass.
+	sent := []int64{}
+	var publishErr error
+	for _, e := range pending {
+		if publishErr = publish(e.event); publishErr != nil {
+			break
+		}
+		sent = append(sent, e.id)
+	}
+
+	if _, err := tx.Exec(`UPDATE outbox SET sent_at = CURRENT_TIMESTAMP WHERE id = ANY($1)`, pq.Array(sent)); err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return len(sent), publishErr
+}
+
+// PurgeOutbox removes entries that were sent before the given time.
+func PurgeOutbox(db *sql.DB, before time.Time) error {
+	_, err := db.Exec(`DELETE FROM outbox WHERE sent_at < $1`, before)
+	return err
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// Number of months ahead of the current one for which partitions of the
+// events table are kept ready.
+const PartitionsAhead = 3
+
+// Key of the advisory lock serializing the creation of partitions.
+const partitionLock = 7301001
+
+// partitionName names the partition of the events table holding the events
+// of the month t falls in.
+func partitionName(t time.Time) string {
+	return fmt.Sprintf("events_p%04d_%02d", t.Year(), t.Month())
+}
+
+// monthOf returns the start of the month t falls in.
+func monthOf(t time.Time) time.Time {
+	return time.Date(t.Year(), t.Month(), 1, 0, 0, 0, 0, time.UTC)
+}
+
+// CreatePartitions makes sure the events table has a partition for every
+// month from the one holding from up to and including the one holding to.
+func CreatePartitions(db *sql.DB, from, to time.Time) error {
+	months := []time.Time{}
+	for m := monthOf(from); !m.After(monthOf(to)); m = m.AddDate(0, 1, 0) {
+		months = append(months, m)
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createPartitions(tx, months); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func createPartitions(tx *sql.Tx, months []time.Time) error {
+	// Concurrent creation of the same table can fail despite IF NOT EXISTS.
+	if _, err := tx.Exec(`SELECT pg_advisory_xact_lock($1)`, partitionLock); err != nil {
+		return err
+	}
+
+	for _, m := range months {
+		if _, err := tx.Exec(fmt.Sprintf(
+			`CREATE TABLE IF NOT EXISTS %s PARTITION OF events FOR VALUES FROM ('%s') TO ('%s')`,
+			pq.QuoteIdentifier(partitionName(m)),
+			m.Format("2006-01-02"),
+			m.AddDate(0, 1, 0).Format("2006-01-02"),
+		)); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ensurePartitions creates any partitions missing for the events about to be
+// written, such as those of a backfill of old events.
+func ensurePartitions(db *sql.DB, list []events.GenericEvent) error {
+	seen := map[string]bool{}
+	names := []string{}
+	for _, event := range list {
+		name := partitionName(event.Timestamp)
+		if !seen[name] {
+			seen[name] = true
+			names = append(names, name)
+		}
+	}
+	if len(names) == 0 {
+		return nil
+	}
+
+	rows, err := db.Query(`SELECT name FROM UNNEST($1::TEXT[]) AS name WHERE to_regclass(name) IS NULL`, pq.Array(names))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	missing := map[string]bool{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return err
+		}
+		missing[name] = true
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+
+	months := []time.Time{}
+	for _, event := range list {
+		name := partitionName(event.Timestamp)
+		if missing[name] {
+			months = append(months, monthOf(event.Timestamp))
+			delete(missing, name)
+		}
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if err := createPartitions(tx, months); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
@@ -0,0 +1,121 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"sort"
+	"time"
+)
+
+// QuotaUsage is the number of events under a rate limit rule, named by its
+// pattern, that a subject, an API key or a source, published on a UTC day.
+type QuotaUsage struct {
+	Day     string `json:"day"`
+	Subject string `json:"subject"`
+	Name    string `json:"name"`
+	Count   int64  `json:"count"`
+}
+
+// QuotaReservation adds Count events to the usage of a subject and rule,
+// which may not exceed Limit. A zero Limit means there is none.
+type QuotaReservation struct {
+	Subject string
+	Name    string
+	Count   int64
+	Limit   int64
+}
+
+// quotaDay formats the UTC day t falls on.
+func quotaDay(t time.Time) string {
+	return t.UTC().Format("2006-01-02")
+}
+
+// ReserveQuota adds the reservations to the usage of the day t falls on,
+// unless that would take any of them past its limit. In that case nothing is
+// added and the reservation that didn't fit is returned.
+func ReserveQuota(db *sql.DB, t time.Time, reservations []QuotaReservation) (*QuotaReservation, error) {
+	// Lock rows in the same order everywhere so that concurrent reservations
+	// can't deadlock.
+	sorted := append([]QuotaReservation{}, reservations...)
+	sort.Slice(sorted, func(i, j int) bool {
+		if sorted[i].Subject != sorted[j].Subject {
+			return sorted[i].Subject < sorted[j].Subject
+		}
+		return sorted[i].Name < sorted[j].Name
+	})
+
+	tx, err := db.Begin()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	for i, r := range sorted {
+		if r.Limit > 0 && r.Count > r.Limit {
+			return &sorted[i], nil
+		}
+
+		var count int64
+		err := tx.QueryRow(`INSERT INTO quota_usage (
+				day,
+				subject,
+				name,
+				count
+			) VALUES ($1::DATE, $2, $3, $4) ON CONFLICT (day, subject, name) DO UPDATE SET
+				count=quota_usage.count + EXCLUDED.count
+			WHERE
+				$5::BIGINT = 0 OR quota_usage.count + EXCLUDED.count <= $5::BIGINT
+			RETURNING count
+		`, quotaDay(t), r.Subject, r.Name, r.Count, r.Limit).Scan(&count)
+		if errors.Is(err, sql.ErrNoRows) {
+			return &sorted[i], nil
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return nil, tx.Commit()
+}
+
+// ReleaseQuota takes back reservations made on the day t falls on, for
+// instance because the events they were made for could not be queued.
+func ReleaseQuota(db *sql.DB, t time.Time, reservations []QuotaReservation) error {
+	for _, r := range reservations {
+		if _, err := db.Exec(`UPDATE quota_usage SET count=GREATEST(count - $4::BIGINT, 0) WHERE day=$1::DATE AND subject=$2 AND name=$3`,
+			quotaDay(t), r.Subject, r.Name, r.Count); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ListQuotaUsage returns the usage on the day t falls on, optionally of a
+// single subject, ordered by subject and name.
+func ListQuotaUsage(db *sql.DB, t time.Time, subject *string) ([]QuotaUsage, error) {
+	rows, err := db.Query(`SELECT to_char(day, 'YYYY-MM-DD'), subject, name, count FROM quota_usage
+		WHERE day=$1::DATE AND ($2::VARCHAR IS NULL OR subject=$2)
+		ORDER BY subject, name
+	`, quotaDay(t), subject)
+	if err != nil {
+		return []QuotaUsage{}, err
+	}
+	defer rows.Close()
+
+	list := []QuotaUsage{}
+	for rows.Next() {
+		var usage QuotaUsage
+		if err := rows.Scan(&usage.Day, &usage.Subject, &usage.Name, &usage.Count); err != nil {
+			return list, err
+		}
+		list = append(list, usage)
+	}
+
+	return list, rows.Err()
+}
+
+// PurgeQuotaUsage removes the usage of days before the one t falls on.
+func PurgeQuotaUsage(db *sql.DB, t time.Time) error {
+	_, err := db.Exec(`DELETE FROM quota_usage WHERE day < $1::DATE`, quotaDay(t))
+	return err
+}
@@ -0,0 +1,247 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"time"
+)
+
+// States a replay goes through.
+const (
+	ReplayPending   = "pending"
+	ReplayRunning   = "running"
+	ReplayCompleted = "completed"
+	ReplayFailed    = "failed"
+	ReplayCancelled = "cancelled"
+)
+
+// Replay is a job re-publishing stored events to an exchange.
+type Replay struct {
+	Id     string      `json:"id"`
+	Status string      `json:"status"`
+	Filter EventFilter `json:"filter"`
+
+	// Exchange the events are published to, and the routing key used for
+	// all of them. An empty routing key routes each event by its name.
+	Exchange   string `json:"exchange"`
+	RoutingKey string `json:"routing_key,omitempty"`
+
+	// Maximum number of events published per second, or 0 for no limit.
+	Rate int `json:"rate"`
+
+	Total     int64  `json:"total"`
+	Published int64  `json:"published"`
+	Error     string `json:"error,omitempty"`
+
+	// Position of the last event published, so that an interrupted replay
+	// can pick up where it left off.
+	Cursor string `json:"-"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ErrNoSuchReplay is returned when looking up a replay that doesn't exist.
+var ErrNoSuchReplay = errors.New("no such replay")
+
+// ErrReplayLost is returned when saving a replay that the process no longer
+// holds the lease of, or that was cancelled or finished elsewhere.
+var ErrReplayLost = errors.New("replay lease lost")
+
+func CreateReplay(db *sql.DB, r Replay) (Replay, error) {
+	filter, err := json.Marshal(r.Filter)
+	if err != nil {
+		return r, err
+	}
+
+	err = db.QueryRow(`INSERT INTO replays (
+			id,
+			status,
+			filter,
+			exchange,
+			routing_key,
+			rate,
+			total
+		) VALUES ($1, $2, $3, $4, NULLIF($5, ''), $6, $7)
+		RETURNING created_at, updated_at
+	`, r.Id, r.Status, filter, r.Exchange, r.RoutingKey, r.Rate, r.Total).Scan(&r.CreatedAt, &r.UpdatedAt)
+
+	return r, err
+}
+
+// UpdateReplay saves the progress of a replay run by the owner and renews its
+// lease. ErrReplayLost is returned if the owner no longer holds the lease or
+// the replay was cancelled in the meantime.
+func UpdateReplay(db *sql.DB, r Replay, owner string, lease time.Duration) error {
+	result, err := db.Exec(`UPDATE replays SET
+			status=$2,
+			total=$3,
+			published=$4,
+			cursor=NULLIF($5, ''),
+			error=NULLIF($6, ''),
+			lease_expires=CURRENT_TIMESTAMP + make_interval(secs => $8),
+			updated_at=CURRENT_TIMESTAMP
+		WHERE
+			id=$1 AND owner=$7 AND status IN ($9, $10)
+	`, r.Id, r.Status, r.Total, r.Published, r.Cursor, r.Error, owner, lease.Seconds(), ReplayPending, ReplayRunning)
+	if err != nil {
+		return err
+	}
+	return replayUpdated(result)
+}
+
+// RenewReplayLease extends the owner's lease of a replay. ErrReplayLost is
+// returned if the owner no longer holds the lease or the replay was cancelled
+// in the meantime.
+func RenewReplayLease(db *sql.DB, id, owner string, lease time.Duration) error {
+	result, err := db.Exec(`UPDATE replays SET
+			lease_expires=CURRENT_TIMESTAMP + make_interval(secs => $3)
+		WHERE
+			id=$1 AND owner=$2 AND status IN ($4, $5)
+	`, id, owner, lease.Seconds(), ReplayPending, ReplayRunning)
+	if err != nil {
+		return err
+	}
+	return replayUpdated(result)
+}
+
+func replayUpdated(result sql.Result) error {
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrReplayLost
+	}
+	return nil
+}
+
+// CancelReplay marks a pending or running replay as cancelled, which stops
+// whichever process runs it. It returns false if the replay had already
+// finished.
+func CancelReplay(db *sql.DB, id string) (bool, error) {
+	result, err := db.Exec(`UPDATE replays SET
+			status=$2,
+			updated_at=CURRENT_TIMESTAMP
+		WHERE
+			id=$1 AND status IN ($3, $4)
+	`, id, ReplayCancelled, ReplayPending, ReplayRunning)
+	if err != nil {
+		return false, err
+	}
+	n, err := result.RowsAffected()
+	return n > 0, err
+}
+
+const selectReplays = `SELECT
+		id,
+		status,
+		filter,
+		exchange,
+		COALESCE(routing_key, ''),
+		rate,
+		total,
+		published,
+		COALESCE(cursor, ''),
+		COALESCE(error, ''),
+		created_at,
+		updated_at
+	FROM replays`
+
+func scanReplays(rows *sql.Rows) ([]Replay, error) {
+	defer rows.Close()
+
+	list := []Replay{}
+	for rows.Next() {
+		var r Replay
+		var filter []byte
+		if err := rows.Scan(&r.Id, &r.Status, &filter, &r.Exchange, &r.RoutingKey, &r.Rate, &r.Total, &r.Published, &r.Cursor, &r.Error, &r.CreatedAt, &r.UpdatedAt); err != nil {
+			return list, err
+		}
+		if err := json.Unmarshal(filter, &r.Filter); err != nil {
+			return list, err
+		}
+		list = append(list, r)
+	}
+
+	return list, rows.Err()
+}
+
+func GetReplay(db *sql.DB, id string) (Replay, error) {
+	rows, err := db.Query(selectReplays+" WHERE id = $1", id)
+	if err != nil {
+		return Replay{}, err
+	}
+
+	list, err := scanReplays(rows)
+	if err != nil {
+		return Replay{}, err
+	}
+	if len(list) == 0 {
+		return Replay{}, ErrNoSuchReplay
+	}
+
+	return list[0], nil
+}
+
+// ListReplays returns the most recently created replays.
+func ListReplays(db *sql.DB, limit int) ([]Replay, error) {
+	rows, err := db.Query(selectReplays+" ORDER BY created_at DESC LIMIT $1", limit)
+	if err != nil {
+		return []Replay{}, err
+	}
+	return scanReplays(rows)
+}
+
+// Columns of the replays claimed by ClaimReplay and ClaimReplays.
+const claimedReplays = ` RETURNING
+		id,
+		status,
+		filter,
+		exchange,
+		COALESCE(routing_key, ''),
+		rate,
+		total,
+		published,
+		COALESCE(cursor, ''),
+		COALESCE(error, ''),
+		created_at,
+		updated_at`
+
+// ClaimReplay takes the lease of a pending or running replay for the owner,
+// unless another process holds it. It returns false if the replay wasn't
+// claimed.
+func ClaimReplay(db *sql.DB, id, owner string, lease time.Duration) (Replay, bool, error) {
+	rows, err := db.Query(`UPDATE replays SET
+			owner=$2,
+			lease_expires=CURRENT_TIMESTAMP + make_interval(secs => $3)
+		WHERE
+			id=$1 AND status IN ($4, $5) AND (lease_expires IS NULL OR lease_expires < CURRENT_TIMESTAMP)
+	`+claimedReplays, id, owner, lease.Seconds(), ReplayPending, ReplayRunning)
+	if err != nil {
+		return Replay{}, false, err
+	}
+
+	list, err := scanReplays(rows)
+	if err != nil || len(list) == 0 {
+		return Replay{}, false, err
+	}
+	return list[0], true, nil
+}
+
+// ClaimReplays takes the leases of the pending and running replays that no
+// process holds, such as those interrupted when a server stopped, for the
+// owner.
+func ClaimReplays(db *sql.DB, owner string, lease time.Duration) ([]Replay, error) {
+	rows, err := db.Query(`UPDATE replays SET
+			owner=$1,
+			lease_expires=CURRENT_TIMESTAMP + make_interval(secs => $2)
+		WHERE
+			status IN ($3, $4) AND (lease_expires IS NULL OR lease_expires < CURRENT_TIMESTAMP)
+	`+claimedReplays, owner, lease.Seconds(), ReplayPending, ReplayRunning)
+	if err != nil {
+		return []Replay{}, err
+	}
+	return scanReplays(rows)
+}
@@ -0,0 +1,218 @@
+package db
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// Name of the retention policy applying to events without one of their own.
+const DefaultRetentionPolicy = "*"
+
+// Key of the advisory lock held while retention policies are enforced.
+const retentionLock = 7301002
+
+// RetentionPolicy sets how long events with a name are kept. Events are kept
+// forever when KeepDays is nil.
+type RetentionPolicy struct {
+	Name      string    `json:"name"`
+	KeepDays  *int      `json:"keep_days"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+var ErrNoSuchRetentionPolicy = errors.New("no such retention policy exists")
+
+// PutRetentionPolicy creates or replaces the retention policy for a name.
+func PutRetentionPolicy(db *sql.DB, name string, keepDays *int) (RetentionPolicy, error) {
+	policy := RetentionPolicy{Name: name, KeepDays: keepDays}
+	err := db.QueryRow(`INSERT INTO retention_policies (
+			name,
+			keep_days
+		) VALUES ($1, $2) ON CONFLICT (name) DO UPDATE SET
+			keep_days=$2,
+			updated_at=CURRENT_TIMESTAMP
+		RETURNING created_at, updated_at
+	`, name, keepDays).Scan(&policy.CreatedAt, &policy.UpdatedAt)
+	return policy, err
+}
+
+// ListRetentionPolicies returns every retention policy ordered by name.
+func ListRetentionPolicies(db *sql.DB) ([]RetentionPolicy, error) {
+	rows, err := db.Query(`SELECT name, keep_days, created_at, updated_at FROM retention_policies ORDER BY name`)
+	if err != nil {
+		return []RetentionPolicy{}, err
+	}
+	defer rows.Close()
+
+	list := []RetentionPolicy{}
+	for rows.Next() {
+		var policy RetentionPolicy
+		var keepDays sql.NullInt64
+		if err := rows.Scan(&policy.Name, &keepDays, &policy.CreatedAt, &policy.UpdatedAt); err != nil {
+			return list, err
+		}
+		if keepDays.Valid {
+			days := int(keepDays.Int64)
+			policy.KeepDays = &days
+		}
+		list = append(list, policy)
+	}
+
+	return list, rows.Err()
+}
+
+// DeleteRetentionPolicy removes the retention policy for a name.
+func DeleteRetentionPolicy(db *sql.DB, name string) error {
+	result, err := db.Exec(`DELETE FROM retention_policies WHERE name=$1`, name)
+	if err != nil {
+		return err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return ErrNoSuchRetentionPolicy
+	}
+
+	return nil
+}
+
+// keepDays evaluates to the number of days the event e is kept, preferring
+// the policy for its name over the default one. It is NULL when the event is
+// kept forever.
+const keepDays = `(SELECT p.keep_days FROM retention_policies p
+	WHERE p.name IN (e.name, '` + DefaultRetentionPolicy + `')
+	ORDER BY p.name = '` + DefaultRetentionPolicy + `'
+	LIMIT 1)`
+
+// expired evaluates to true when the event e is past its retention period as
+// of $1.
+const expired = `COALESCE(e.timestamp < $1::TIMESTAMP - make_interval(days => ` + keepDays + `), FALSE)`
+
+// RetentionResult reports what EnforceRetention removed.
+type RetentionResult struct {
+	Dropped []string
+	Deleted int64
+}
+
+// EnforceRetention removes the events that are past their retention period as
+// of now. Partitions holding only expired events are dropped in one go and any
+// remaining expired events are deleted. Nothing is done while another process
+// is enforcing retention.
+func EnforceRetention(db *sql.DB, now time.Time) (RetentionResult, error) {
+	result := RetentionResult{Dropped: []string{}}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1)`, retentionLock).Scan(&locked); err != nil {
+		return result, err
+	}
+	if !locked {
+		return result, nil
+	}
+
+	// Nothing newer than the shortest retention period can have expired.
+	var shortest sql.NullInt64
+	if err := tx.QueryRow(`SELECT MIN(keep_days) FROM retention_policies`).Scan(&shortest); err != nil {
+		return result, err
+	}
+	if !shortest.Valid {
+		return result, nil
+	}
+	cutoff := now.AddDate(0, 0, -int(shortest.Int64))
+
+	partitions, err := listPartitions(tx)
+	if err != nil {
+		return result, err
+	}
+
+	// Partition bounds are in the same wall clock time as the timestamps.
+	wall := time.Date(cutoff.Year(), cutoff.Month(), cutoff.Day(), cutoff.Hour(), cutoff.Minute(), cutoff.Second(), cutoff.Nanosecond(), time.UTC)
+	for _, partition := range partitions {
+		if !partition.end.Before(wall) {
+			continue
+		}
+
+		var kept bool
+		if err := tx.QueryRow(fmt.Sprintf(
+			`SELECT EXISTS (SELECT 1 FROM %s e WHERE NOT %s)`,
+			pq.QuoteIdentifier(partition.name),
+			expired,
+		), now).Scan(&kept); err != nil {
+			return result, err
+		}
+		if kept {
+			continue
+		}
+
+		if _, err := tx.Exec(fmt.Sprintf(
+			`DELETE FROM event_ids i USING %s e WHERE i.id = e.id AND i.timestamp = e.timestamp`,
+			pq.QuoteIdentifier(partition.name),
+		)); err != nil {
+			return result, err
+		}
+		if _, err := tx.Exec(`DROP TABLE ` + pq.QuoteIdentifier(partition.name)); err != nil {
+			return result, err
+		}
+		result.Dropped = append(result.Dropped, partition.name)
+	}
+
+	// The ids of the deleted events are freed along with them.
+	if err := tx.QueryRow(`WITH deleted AS (
+			DELETE FROM events e WHERE e.timestamp < $2::TIMESTAMP AND `+expired+` RETURNING e.id, e.timestamp
+		), ids AS (
+			DELETE FROM event_ids i USING deleted d WHERE i.id = d.id AND i.timestamp = d.timestamp
+		)
+		SELECT COUNT(*) FROM deleted`, now, cutoff).Scan(&result.Deleted); err != nil {
+		return result, err
+	}
+
+	return result, tx.Commit()
+}
+
+type partition struct {
+	name string
+	end  time.Time
+}
+
+// listPartitions returns the monthly partitions of the events table along
+// with the end of the month each of them holds.
+func listPartitions(tx *sql.Tx) ([]partition, error) {
+	rows, err := tx.Query(`SELECT c.relname FROM pg_inherits i
+		JOIN pg_class c ON c.oid = i.inhrelid
+		WHERE i.inhparent = 'events'::REGCLASS
+		ORDER BY c.relname
+	`)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	list := []partition{}
+	for rows.Next() {
+		var name string
+		if err := rows.Scan(&name); err != nil {
+			return list, err
+		}
+
+		// Skip tables attached by hand.
+		start, err := time.Parse("events_p2006_01", name)
+		if err != nil {
+			continue
+		}
+		list = append(list, partition{name: name, end: start.AddDate(0, 1, 0)})
+	}
+
+	return list, rows.Err()
+}
@@ -0,0 +1,78 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// RewrapFailure is an event whose data key couldn't be rewrapped, such as for
+// being wrapped with a master key that was removed.
+type RewrapFailure struct {
+	Id    string `json:"id"`
+	Error string `json:"error"`
+}
+
+// RewrapResult tells how a batch of rewrapping went. Cursor is the ID of the
+// last event looked at, to be passed to the next call.
+type RewrapResult struct {
+	Rewrapped int             `json:"rewrapped"`
+	Failed    []RewrapFailure `json:"failed"`
+	Remaining bool            `json:"remaining"`
+	Cursor    string          `json:"next_cursor,omitempty"`
+}
+
+// RewrapEvents rewrites the bodies of up to limit stored events whose data
+// keys are wrapped with a master key other than the current one, using the
+// rewrap function to work out the new bodies. Events are taken in order of
+// their IDs, starting after the given one, so that events which can't be
+// rewrapped are reported and passed over rather than stopping the rest.
+func RewrapEvents(db *sql.DB, currentKeyId, after string, limit int, rewrap func(events.GenericEvent) (json.RawMessage, bool, error)) (RewrapResult, error) {
+	result := RewrapResult{Failed: []RewrapFailure{}}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return result, err
+	}
+	defer tx.Rollback()
+
+	list, err := scanEvents(tx.Query(`SELECT id, timestamp, name, source, body FROM events
+		WHERE body ? '$envelope' AND body->'$envelope'->>'kid' <> $1 AND id > $2
+		ORDER BY id
+		LIMIT $3
+		FOR UPDATE SKIP LOCKED
+	`, currentKeyId, after, limit+1))
+	if err != nil {
+		return result, err
+	}
+
+	result.Remaining = len(list) > limit
+	if result.Remaining {
+		list = list[:limit]
+	}
+
+	for _, event := range list {
+		result.Cursor = event.Id
+
+		body, changed, err := rewrap(event)
+		if err != nil {
+			result.Failed = append(result.Failed, RewrapFailure{Id: event.Id, Error: err.Error()})
+			continue
+		}
+		if !changed {
+			// The envelope is there but lacks a data key to rewrap.
+			result.Failed = append(result.Failed, RewrapFailure{Id: event.Id, Error: "malformed envelope"})
+			continue
+		}
+		if _, err := tx.Exec(`UPDATE events SET body=$1 WHERE id=$2 AND timestamp=$3`, body, event.Id, event.Timestamp); err != nil {
+			return result, err
+		}
+		result.Rewrapped++
+	}
+	if !result.Remaining {
+		result.Cursor = ""
+	}
+
+	return result, tx.Commit()
+}
@@ -0,0 +1,114 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Schema is a JSON Schema registered for the body of events with a given name.
+type Schema struct {
+	Name      string          `json:"name"`
+	Version   int             `json:"version"`
+	Schema    json.RawMessage `json:"schema"`
+	CreatedAt time.Time       `json:"created_at"`
+}
+
+// ErrNoSuchSchema is returned when looking up a schema that isn't registered.
+var ErrNoSuchSchema = errors.New("no such schema")
+
+// CreateSchema registers a new version of the schema for the named event.
+func CreateSchema(db *sql.DB, name string, schema json.RawMessage) (Schema, error) {
+	s := Schema{Name: name, Schema: schema}
+
+	err := db.QueryRow(`INSERT INTO schemas (
+			name,
+			version,
+			schema
+		) SELECT $1, COALESCE(MAX(version), 0) + 1, $2 FROM schemas WHERE name = $1
+		RETURNING version, created_at
+	`, name, []byte(schema)).Scan(&s.Version, &s.CreatedAt)
+
+	return s, err
+}
+
+// GetSchema returns a specific version of the schema for the named event, or
+// the latest version if version is 0.
+func GetSchema(db *sql.DB, name string, version int) (Schema, error) {
+	s := Schema{}
+
+	var row *sql.Row
+	if version == 0 {
+		row = db.QueryRow(`SELECT name, version, schema, created_at FROM schemas
+			WHERE name = $1
+			ORDER BY version DESC
+			LIMIT 1
+		`, name)
+	} else {
+		row = db.QueryRow(`SELECT name, version, schema, created_at FROM schemas
+			WHERE name = $1 AND version = $2
+		`, name, version)
+	}
+
+	var data []byte
+	if err := row.Scan(&s.Name, &s.Version, &data, &s.CreatedAt); err != nil {
+		if errors.Is(err, sql.ErrNoRows) {
+			return s, ErrNoSuchSchema
+		}
+		return s, err
+	}
+	s.Schema = data
+
+	return s, nil
+}
+
+// ListSchemas returns the latest version of every registered schema.
+func ListSchemas(db *sql.DB) ([]Schema, error) {
+	rows, err := db.Query(`SELECT DISTINCT ON (name) name, version, schema, created_at FROM schemas
+		ORDER BY name, version DESC
+	`)
+	if err != nil {
+		return []Schema{}, err
+	}
+	defer rows.Close()
+
+	list := []Schema{}
+	for rows.Next() {
+		var s Schema
+		var data []byte
+		if err := rows.Scan(&s.Name, &s.Version, &data, &s.CreatedAt); err != nil {
+			return list, err
+		}
+		s.Schema = data
+		list = append(list, s)
+	}
+
+	return list, rows.Err()
+}
+
+// DeleteSchema removes a version of the schema for the named event, or every
+// version if version is 0.
+func DeleteSchema(db *sql.DB, name string, version int) error {
+	var res sql.Result
+	var err error
+	if version == 0 {
+		res, err = db.Exec(`DELETE FROM schemas WHERE name = $1`, name)
+	} else {
+		res, err = db.Exec(`DELETE FROM schemas WHERE name = $1 AND version = $2`, name, version)
+	}
+	if err != nil {
+		return err
+	}
+
+	count, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if count == 0 {
+		return fmt.Errorf("%w for \"%s\"", ErrNoSuchSchema, name)
+	}
+
+	return nil
+}
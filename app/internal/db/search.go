@@ -0,0 +1,106 @@
+package db
+
+import (
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strconv"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// Options for ts_headline: a couple of fragments around the matching terms,
+// which are wrapped in <mark> tags.
+const headlineOptions = "MaxFragments=2, MaxWords=30, MinWords=10, FragmentDelimiter=\" … \", StartSel=<mark>, StopSel=</mark>"
+
+// SearchCursor marks how far into the ranked results of a search a page
+// starts.
+type SearchCursor struct {
+	Offset int
+}
+
+// String encodes the cursor as an opaque, URL-safe token.
+func (c SearchCursor) String() string {
+	return base64.RawURLEncoding.EncodeToString([]byte("search|" + strconv.Itoa(c.Offset)))
+}
+
+// ParseSearchCursor decodes a token previously produced by
+// SearchCursor.String.
+func ParseSearchCursor(token string) (SearchCursor, error) {
+	data, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil || len(data) < 7 || string(data[:7]) != "search|" {
+		return SearchCursor{}, errors.New("malformed cursor")
+	}
+
+	offset, err := strconv.Atoi(string(data[7:]))
+	if err != nil || offset < 0 {
+		return SearchCursor{}, errors.New("malformed cursor")
+	}
+
+	return SearchCursor{Offset: offset}, nil
+}
+
+// SearchEvents runs a web search style query, such as `"interest rates" -fed`,
+// against the text of event bodies and returns at most limit matching events
+// that also match the filter, best match first. Each result carries its rank
+// and a snippet with the matching terms highlighted. The returned cursor is
+// nil once there are no more results.
+func SearchEvents(db *sql.DB, query string, filter EventFilter, limit int, after *SearchCursor) ([]events.SearchResult, *SearchCursor, error) {
+	offset := 0
+	if after != nil {
+		offset = after.Offset
+	}
+
+	where, args := filter.where([]any{query})
+	if where == "" {
+		where = " WHERE search @@ q"
+	} else {
+		where += " AND search @@ q"
+	}
+
+	// Fetch one extra row to find out whether there is another page.
+	args = append(args, limit+1, offset)
+
+	// Headlines are costly so they are only worked out for the page itself.
+	rows, err := db.Query(fmt.Sprintf(`SELECT
+			id,
+			timestamp,
+			name,
+			source,
+			body,
+			rank,
+			ts_headline('english', concat_ws(' … ', body->>'title', body->>'excerpt', body->>'content'), q, '%s')
+		FROM (
+			SELECT id, timestamp, name, source, body, ts_rank_cd(search, q) AS rank, q
+			FROM events, websearch_to_tsquery('english', $1) AS q
+			%s
+			ORDER BY rank DESC, timestamp DESC, id DESC
+			LIMIT $%d OFFSET $%d
+		) AS page
+		ORDER BY rank DESC, timestamp DESC, id DESC
+	`, headlineOptions, where, len(args)-1, len(args)), args...)
+	if err != nil {
+		return []events.SearchResult{}, nil, err
+	}
+	defer rows.Close()
+
+	list := []events.SearchResult{}
+	for rows.Next() {
+		var result events.SearchResult
+		event := &result.Event
+		if err := rows.Scan(&event.Id, &event.Timestamp, &event.Name, &event.Source, &event.Body, &result.Rank, &result.Headline); err != nil {
+			return list, nil, err
+		}
+		list = append(list, result)
+	}
+	if err := rows.Err(); err != nil {
+		return list, nil, err
+	}
+
+	if len(list) <= limit {
+		return list, nil, nil
+	}
+
+	return list[:limit], &SearchCursor{Offset: offset + limit}, nil
+}
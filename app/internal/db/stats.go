@@ -0,0 +1,130 @@
+package db
+
+import (
+	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// Key of the advisory lock held while the hourly counts are refreshed.
+const rollupLock = 7301003
+
+// Intervals events may be counted over, and the columns they may be grouped
+// by.
+var (
+	StatsIntervals = map[string]time.Duration{
+		"minute": time.Minute,
+		"hour":   time.Hour,
+		"day":    24 * time.Hour,
+	}
+	StatsGroups = map[string]bool{
+		"name":   true,
+		"source": true,
+	}
+)
+
+// CountEventsOverTime counts the events matching the filter in buckets of the
+// given interval, optionally broken down by name and/or source. Buckets
+// without events are left out. With rollup set the counts come from the hourly
+// rollup table, which only supports hour and day intervals, filters on
+// neither name patterns nor bodies, and counts whole hours.
+func CountEventsOverTime(db *sql.DB, filter EventFilter, interval string, groupBy []string, rollup bool) ([]events.StatsBucket, error) {
+	if _, ok := StatsIntervals[interval]; !ok {
+		return nil, fmt.Errorf("unknown interval %q", interval)
+	}
+
+	table, count := "events", "COUNT(*)"
+	if rollup {
+		if interval == "minute" || filter.Pattern != nil || len(filter.NamePatterns) > 0 || len(filter.ExcludedNamePatterns) > 0 || len(filter.Body) > 0 {
+			return nil, fmt.Errorf("rollups can't serve this query")
+		}
+		table, count = "event_counts_hourly", "SUM(count)"
+	}
+
+	columns := []string{"date_trunc($1, timestamp)"}
+	for _, group := range groupBy {
+		if !StatsGroups[group] {
+			return nil, fmt.Errorf("unknown group %q", group)
+		}
+		columns = append(columns, group)
+	}
+
+	where, args := filter.where([]any{interval})
+
+	groups := []string{}
+	for i := range columns {
+		groups = append(groups, fmt.Sprint(i+1))
+	}
+
+	rows, err := db.Query(fmt.Sprintf(
+		"SELECT %s, %s FROM %s%s GROUP BY %s ORDER BY %s",
+		strings.Join(columns, ", "), count, table, where,
+		strings.Join(groups, ", "), strings.Join(groups, ", "),
+	), args...)
+	if err != nil {
+		return []events.StatsBucket{}, err
+	}
+	defer rows.Close()
+
+	list := []events.StatsBucket{}
+	for rows.Next() {
+		var bucket events.StatsBucket
+		dest := []any{&bucket.Timestamp}
+		for _, group := range groupBy {
+			switch group {
+			case "name":
+				dest = append(dest, &bucket.Name)
+			case "source":
+				dest = append(dest, &bucket.Source)
+			}
+		}
+		dest = append(dest, &bucket.Count)
+
+		if err := rows.Scan(dest...); err != nil {
+			return list, err
+		}
+		list = append(list, bucket)
+	}
+
+	return list, rows.Err()
+}
+
+// RefreshEventCounts recounts the hourly rollup of events from the hour since
+// falls in onwards, or all of it if since is nil. Nothing is done while
+// another process is refreshing the counts.
+func RefreshEventCounts(db *sql.DB, since *time.Time) error {
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var locked bool
+	if err := tx.QueryRow(`SELECT pg_try_advisory_xact_lock($1)`, rollupLock).Scan(&locked); err != nil {
+		return err
+	}
+	if !locked {
+		return nil
+	}
+
+	where, args := "", []any{}
+	if since != nil {
+		where, args = " WHERE timestamp >= date_trunc('hour', $1::TIMESTAMP)", []any{*since}
+	}
+
+	if _, err := tx.Exec(`DELETE FROM event_counts_hourly`+where, args...); err != nil {
+		return err
+	}
+
+	if _, err := tx.Exec(`INSERT INTO event_counts_hourly (timestamp, name, source, count)
+		SELECT date_trunc('hour', timestamp), name, source, COUNT(*) FROM events`+where+`
+		GROUP BY 1, 2, 3
+	`, args...); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
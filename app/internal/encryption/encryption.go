@@ -0,0 +1,345 @@
+package encryption
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// Bodies with encrypted fields carry an envelope under this key holding the
+// wrapped data key.
+const EnvelopeKey = "$envelope"
+
+// Prefix of encrypted field values.
+const prefix = "enc:v1:"
+
+// IsSealed reports whether a body value was encrypted by an Encrypter.
+func IsSealed(s string) bool {
+	return strings.HasPrefix(s, prefix)
+}
+
+// ErrReservedField is returned when a body to be encrypted already has a
+// field named after the envelope.
+var ErrReservedField = errors.New("body must not have a " + EnvelopeKey + " field")
+
+// Envelope holds the data key that the fields of a body were encrypted with,
+// wrapped by a master key, along with blind indexes of the plaintext strings
+// so that events can still be found by them.
+type Envelope struct {
+	KeyId   string   `json:"kid"`
+	DataKey string   `json:"dek"`
+	Indexes []string `json:"idx,omitempty"`
+}
+
+// Encrypter seals the sensitive fields of event bodies. A nil Encrypter
+// leaves events as they are.
+type Encrypter struct {
+	keys KeyProvider
+
+	// Dot separated paths of the sensitive fields, by event name.
+	fields map[string][][]string
+}
+
+// NewEncrypter returns an Encrypter sealing the given fields, which are
+// listed by event name as dot separated paths into the body.
+func NewEncrypter(keys KeyProvider, fields map[string][]string) *Encrypter {
+	e := &Encrypter{keys: keys, fields: map[string][][]string{}}
+	for name, paths := range fields {
+		for _, path := range paths {
+			e.fields[name] = append(e.fields[name], strings.Split(path, "."))
+		}
+	}
+	return e
+}
+
+// LoadFields reads the sensitive fields of each event name from a JSON file
+// such as {"login": ["email", "profile.phone"]}.
+func LoadFields(path string) (map[string][]string, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	fields := map[string][]string{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, fmt.Errorf("malformed fields file: %w", err)
+	}
+	return fields, nil
+}
+
+// BlindIndex returns a keyed hash of a value, ignoring case and surrounding
+// whitespace, that matches the indexes stored in envelopes. A nil Encrypter
+// returns an empty string, which matches nothing.
+func (e *Encrypter) BlindIndex(value string) string {
+	if e == nil {
+		return ""
+	}
+	mac := hmac.New(sha256.New, e.keys.IndexKey())
+	mac.Write([]byte(strings.ToLower(strings.TrimSpace(value))))
+	return hex.EncodeToString(mac.Sum(nil))
+}
+
+// Encrypt seals the sensitive fields of the event's body under a fresh data
+// key. The event must already have its ID, which the data key is bound to.
+// Bodies of any event that already hold an envelope are rejected, so that one
+// can't be passed off as sealed by the server.
+func (e *Encrypter) Encrypt(event events.GenericEvent) (events.GenericEvent, error) {
+	if len(event.Body) == 0 {
+		return event, nil
+	}
+
+	var body map[string]any
+	if err := json.Unmarshal(event.Body, &body); err != nil || body == nil {
+		// Only object bodies have fields to seal.
+		return event, nil
+	}
+	if _, ok := body[EnvelopeKey]; ok {
+		return event, ErrReservedField
+	}
+
+	if e == nil || len(e.fields[event.Name]) == 0 {
+		return event, nil
+	}
+
+	dek := make([]byte, 32)
+	if _, err := rand.Read(dek); err != nil {
+		return event, err
+	}
+
+	envelope := Envelope{}
+	sealed := false
+	for _, path := range e.fields[event.Name] {
+		parent, ok := lookup(body, path[:len(path)-1])
+		if !ok {
+			continue
+		}
+		field := path[len(path)-1]
+		value, ok := parent[field]
+		if !ok || value == nil {
+			continue
+		}
+
+		if s, ok := value.(string); ok {
+			envelope.Indexes = append(envelope.Indexes, e.BlindIndex(s))
+		}
+
+		plaintext, err := json.Marshal(value)
+		if err != nil {
+			return event, err
+		}
+		ciphertext, err := seal(dek, plaintext, []byte(strings.Join(path, ".")))
+		if err != nil {
+			return event, err
+		}
+		parent[field] = prefix + ciphertext
+		sealed = true
+	}
+	if !sealed {
+		return event, nil
+	}
+
+	kid, key := e.keys.Current()
+	wrapped, err := seal(key, dek, []byte(event.Id))
+	if err != nil {
+		return event, err
+	}
+	envelope.KeyId = kid
+	envelope.DataKey = wrapped
+	body[EnvelopeKey] = envelope
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return event, err
+	}
+	event.Body = data
+
+	return event, nil
+}
+
+// Decrypt opens the sealed fields of the event's body and drops its envelope.
+// Events without an envelope are returned as they are.
+func (e *Encrypter) Decrypt(event events.GenericEvent) (events.GenericEvent, error) {
+	body, envelope, ok := parse(event)
+	if e == nil || !ok {
+		return event, nil
+	}
+
+	dek, err := e.unwrap(envelope, event.Id)
+	if err != nil {
+		return event, err
+	}
+
+	if err := open(dek, body, nil); err != nil {
+		return event, err
+	}
+	delete(body, EnvelopeKey)
+
+	data, err := json.Marshal(body)
+	if err != nil {
+		return event, err
+	}
+	event.Body = data
+
+	return event, nil
+}
+
+// Rewrap wraps the data key of the event's body with the current master key.
+// It reports whether the body changed, which it doesn't when the body has no
+// envelope or is already wrapped with the current key.
+func (e *Encrypter) Rewrap(event events.GenericEvent) (json.RawMessage, bool, error) {
+	body, envelope, ok := parse(event)
+	kid, key := e.keys.Current()
+	if !ok || envelope.KeyId == kid {
+		return event.Body, false, nil
+	}
+
+	dek, err := e.unwrap(envelope, event.Id)
+	if err != nil {
+		return event.Body, false, err
+	}
+
+	wrapped, err := seal(key, dek, []byte(event.Id))
+	if err != nil {
+		return event.Body, false, err
+	}
+	envelope.KeyId = kid
+	envelope.DataKey = wrapped
+	body[EnvelopeKey] = envelope
+
+	data, err := json.Marshal(body)
+	return data, err == nil, err
+}
+
+// CurrentKeyId returns the ID of the master key new data keys are wrapped
+// with.
+func (e *Encrypter) CurrentKeyId() string {
+	kid, _ := e.keys.Current()
+	return kid
+}
+
+func (e *Encrypter) unwrap(envelope Envelope, eventId string) ([]byte, error) {
+	key, err := e.keys.Key(envelope.KeyId)
+	if err != nil {
+		return nil, err
+	}
+	return unseal(key, envelope.DataKey, []byte(eventId))
+}
+
+// parse decodes an event body along with its envelope, if it has one.
+func parse(event events.GenericEvent) (map[string]any, Envelope, bool) {
+	var body map[string]any
+	if len(event.Body) == 0 || json.Unmarshal(event.Body, &body) != nil || body == nil {
+		return nil, Envelope{}, false
+	}
+
+	raw, ok := body[EnvelopeKey]
+	if !ok {
+		return nil, Envelope{}, false
+	}
+
+	// Round trip the envelope through JSON to get at its fields.
+	var envelope Envelope
+	data, _ := json.Marshal(raw)
+	if err := json.Unmarshal(data, &envelope); err != nil || envelope.DataKey == "" {
+		return nil, Envelope{}, false
+	}
+
+	return body, envelope, true
+}
+
+// lookup walks down the path of nested objects.
+func lookup(body map[string]any, path []string) (map[string]any, bool) {
+	for _, field := range path {
+		next, ok := body[field].(map[string]any)
+		if !ok {
+			return nil, false
+		}
+		body = next
+	}
+	return body, true
+}
+
+// open replaces every sealed value within the object with its plaintext.
+// Fields are bound to their path so that sealed values can't be moved around.
+func open(dek []byte, object map[string]any, path []string) error {
+	for field, value := range object {
+		if field == EnvelopeKey && len(path) == 0 {
+			continue
+		}
+		fieldPath := append(path[:len(path):len(path)], field)
+
+		switch v := value.(type) {
+		case string:
+			if !strings.HasPrefix(v, prefix) {
+				continue
+			}
+			plaintext, err := unseal(dek, strings.TrimPrefix(v, prefix), []byte(strings.Join(fieldPath, ".")))
+			if err != nil {
+				return err
+			}
+			var decoded any
+			if err := json.Unmarshal(plaintext, &decoded); err != nil {
+				return err
+			}
+			object[field] = decoded
+		case map[string]any:
+			if err := open(dek, v, fieldPath); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// seal encrypts the plaintext with AES-256-GCM, returning the nonce and
+// ciphertext base64 encoded.
+func seal(key, plaintext, additional []byte) (string, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return "", err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+
+	return base64.StdEncoding.EncodeToString(gcm.Seal(nonce, nonce, plaintext, additional)), nil
+}
+
+// unseal reverses seal.
+func unseal(key []byte, encoded string, additional []byte) ([]byte, error) {
+	data, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < gcm.NonceSize() {
+		return nil, errors.New("sealed value is too short")
+	}
+	return gcm.Open(nil, data[:gcm.NonceSize()], data[gcm.NonceSize():], additional)
+}
@@ -0,0 +1,271 @@
+package encryption
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+func newKey(t *testing.T) string {
+	t.Helper()
+
+	key := make([]byte, 32)
+	if _, err := rand.Read(key); err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(key)
+}
+
+// loadKeys writes a key file with the given keys and loads it.
+func loadKeys(t *testing.T, current string, keys map[string]string, index string) *FileKeyProvider {
+	t.Helper()
+
+	data, err := json.Marshal(keyFile{Current: current, Keys: keys, Index: index})
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(path, data, 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	p, err := LoadKeyFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+var testFields = map[string][]string{
+	"login": {"email", "name", "profile.phone", "profile.age", "profile.verified", "tags", "missing.field"},
+}
+
+func newTestEncrypter(t *testing.T) *Encrypter {
+	t.Helper()
+
+	keys := loadKeys(t, "k1", map[string]string{"k1": newKey(t)}, newKey(t))
+	return NewEncrypter(keys, testFields)
+}
+
+func loginEvent(body string) events.GenericEvent {
+	return events.GenericEvent{Id: "event-1", Name: "login", Source: "test", Body: json.RawMessage(body)}
+}
+
+const loginBody = `{
+	"email": "Jane.Doe@Example.com",
+	"name": "Jane",
+	"profile": {"phone": "555-0100", "age": 42, "verified": true, "city": "Paris"},
+	"tags": ["a", "b"],
+	"other": "kept"
+}`
+
+func decode(t *testing.T, data json.RawMessage) map[string]any {
+	t.Helper()
+
+	var body map[string]any
+	if err := json.Unmarshal(data, &body); err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestEncryptDecrypt(t *testing.T) {
+	e := newTestEncrypter(t)
+
+	sealed, err := e.Encrypt(loginEvent(loginBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	body := decode(t, sealed.Body)
+	profile := body["profile"].(map[string]any)
+	for name, value := range map[string]any{
+		"email":            body["email"],
+		"name":             body["name"],
+		"tags":             body["tags"],
+		"profile.phone":    profile["phone"],
+		"profile.age":      profile["age"],
+		"profile.verified": profile["verified"],
+	} {
+		if s, ok := value.(string); !ok || !IsSealed(s) {
+			t.Errorf("expected %s to be sealed, got %v", name, value)
+		}
+	}
+	if body["other"] != "kept" || profile["city"] != "Paris" {
+		t.Errorf("expected other fields to be left alone, got %v", body)
+	}
+
+	envelope, ok := body[EnvelopeKey].(map[string]any)
+	if !ok || envelope["kid"] != "k1" || envelope["dek"] == "" {
+		t.Fatalf("expected an envelope wrapped with k1, got %v", body[EnvelopeKey])
+	}
+	// Only strings are indexed.
+	if indexes, _ := envelope["idx"].([]any); len(indexes) != 3 {
+		t.Errorf("expected 3 blind indexes, got %v", envelope["idx"])
+	}
+
+	opened, err := e.Decrypt(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decode(t, opened.Body), decode(t, json.RawMessage(loginBody)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+}
+
+func TestEncryptLeavesOtherEvents(t *testing.T) {
+	e := newTestEncrypter(t)
+
+	tests := []events.GenericEvent{
+		{Id: "event-1", Name: "tweet", Body: json.RawMessage(`{"email": "jane@example.com"}`)},
+		{Id: "event-1", Name: "login", Body: json.RawMessage(`["jane@example.com"]`)},
+		{Id: "event-1", Name: "login", Body: json.RawMessage(`{"other": "jane@example.com"}`)},
+		{Id: "event-1", Name: "login"},
+	}
+
+	for _, event := range tests {
+		sealed, err := e.Encrypt(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(sealed.Body) != string(event.Body) {
+			t.Errorf("expected %s to be left alone, got %s", event.Body, sealed.Body)
+		}
+	}
+}
+
+func TestDecryptRejectsMovedValue(t *testing.T) {
+	e := newTestEncrypter(t)
+
+	sealed, err := e.Encrypt(loginEvent(loginBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Swap the sealed email and name, both encrypted with the same data key.
+	body := decode(t, sealed.Body)
+	body["email"], body["name"] = body["name"], body["email"]
+	sealed.Body, _ = json.Marshal(body)
+
+	if _, err := e.Decrypt(sealed); err == nil {
+		t.Error("expected a value moved to another path to be rejected")
+	}
+}
+
+func TestDecryptRejectsOtherEventId(t *testing.T) {
+	e := newTestEncrypter(t)
+
+	sealed, err := e.Encrypt(loginEvent(loginBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The data key is bound to the ID of the event it was made for.
+	sealed.Id = "event-2"
+	if _, err := e.Decrypt(sealed); err == nil {
+		t.Error("expected a data key bound to another event to be rejected")
+	}
+}
+
+func TestRewrap(t *testing.T) {
+	k1, k2, index := newKey(t), newKey(t), newKey(t)
+
+	before := NewEncrypter(loadKeys(t, "k1", map[string]string{"k1": k1}, index), testFields)
+	sealed, err := before.Encrypt(loginEvent(loginBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rotate to k2.
+	rotated := NewEncrypter(loadKeys(t, "k2", map[string]string{"k1": k1, "k2": k2}, index), testFields)
+	body, changed, err := rotated.Rewrap(sealed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !changed {
+		t.Fatal("expected the data key to be rewrapped")
+	}
+	rewrapped := sealed
+	rewrapped.Body = body
+
+	if _, changed, err := rotated.Rewrap(rewrapped); err != nil || changed {
+		t.Errorf("expected a rewrapped body to be left alone, got %v, %v", changed, err)
+	}
+
+	// Retire k1.
+	after := NewEncrypter(loadKeys(t, "k2", map[string]string{"k2": k2}, index), testFields)
+
+	opened, err := after.Decrypt(rewrapped)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := decode(t, opened.Body), decode(t, json.RawMessage(loginBody)); !reflect.DeepEqual(got, want) {
+		t.Errorf("expected %v, got %v", want, got)
+	}
+
+	if _, err := after.Decrypt(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey for a body wrapped with k1, got %v", err)
+	}
+	if _, _, err := after.Rewrap(sealed); !errors.Is(err, ErrUnknownKey) {
+		t.Errorf("expected ErrUnknownKey rewrapping a body wrapped with k1, got %v", err)
+	}
+}
+
+func TestBlindIndex(t *testing.T) {
+	e := newTestEncrypter(t)
+
+	index := e.BlindIndex("jane.doe@example.com")
+	for _, value := range []string{"Jane.Doe@Example.com", "JANE.DOE@EXAMPLE.COM", "  jane.doe@example.com\n"} {
+		if got := e.BlindIndex(value); got != index {
+			t.Errorf("expected %q to have the same index", value)
+		}
+	}
+	if e.BlindIndex("john@example.com") == index {
+		t.Error("expected another value to have another index")
+	}
+
+	// Indexes are keyed.
+	if newTestEncrypter(t).BlindIndex("jane.doe@example.com") == index {
+		t.Error("expected another index key to give another index")
+	}
+
+	var none *Encrypter
+	if none.BlindIndex("jane.doe@example.com") != "" {
+		t.Error("expected a nil Encrypter to give no index")
+	}
+
+	// The envelope indexes the plaintext of sealed strings.
+	sealed, err := e.Encrypt(loginEvent(loginBody))
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, envelope, _ := parse(sealed)
+	found := false
+	for _, idx := range envelope.Indexes {
+		found = found || idx == index
+	}
+	if !found {
+		t.Errorf("expected the envelope to hold %s, got %v", index, envelope.Indexes)
+	}
+}
+
+func TestEncryptReservedField(t *testing.T) {
+	body := `{"email": "jane@example.com", "$envelope": {"kid": "k1", "dek": "forged"}}`
+
+	var none *Encrypter
+	for name, e := range map[string]*Encrypter{"configured": newTestEncrypter(t), "nil": none} {
+		for _, eventName := range []string{"login", "tweet"} {
+			event := events.GenericEvent{Id: "event-1", Name: eventName, Body: json.RawMessage(body)}
+			if _, err := e.Encrypt(event); !errors.Is(err, ErrReservedField) {
+				t.Errorf("%s encrypter, %s event: expected ErrReservedField, got %v", name, eventName, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package encryption
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+)
+
+// ErrUnknownKey is returned when data was sealed with a master key that the
+// provider doesn't hold.
+var ErrUnknownKey = errors.New("unknown master key")
+
+// KeyProvider holds the master keys that wrap data keys.
+type KeyProvider interface {
+	// Current returns the ID and value of the key that new data keys are
+	// wrapped with.
+	Current() (string, []byte)
+
+	// Key returns the key with the given ID.
+	Key(id string) ([]byte, error)
+
+	// IndexKey returns the key used to compute blind indexes. It must not
+	// change when master keys are rotated, or existing indexes stop matching.
+	IndexKey() []byte
+}
+
+// A key file holds base64 encoded 256-bit keys:
+//
+//	{
+//	  "current": "2022-06",
+//	  "keys": {
+//	    "2022-01": "…",
+//	    "2022-06": "…"
+//	  },
+//	  "index": "…"
+//	}
+//
+// Keys are rotated by adding a new key and making it current. Older keys must
+// be kept until every event has been rewrapped with the current one.
+type keyFile struct {
+	Current string            `json:"current"`
+	Keys    map[string]string `json:"keys"`
+	Index   string            `json:"index"`
+}
+
+// FileKeyProvider serves master keys loaded from a local key file.
+type FileKeyProvider struct {
+	current string
+	keys    map[string][]byte
+	index   []byte
+}
+
+// LoadKeyFile reads master keys from the key file at path.
+func LoadKeyFile(path string) (*FileKeyProvider, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var file keyFile
+	if err := json.Unmarshal(data, &file); err != nil {
+		return nil, fmt.Errorf("malformed key file: %w", err)
+	}
+
+	p := &FileKeyProvider{current: file.Current, keys: map[string][]byte{}}
+	for id, encoded := range file.Keys {
+		key, err := decodeKey(encoded)
+		if err != nil {
+			return nil, fmt.Errorf("key %q: %w", id, err)
+		}
+		p.keys[id] = key
+	}
+
+	if _, ok := p.keys[p.current]; !ok {
+		return nil, fmt.Errorf("current key %q is not in the key file", p.current)
+	}
+
+	if p.index, err = decodeKey(file.Index); err != nil {
+		return nil, fmt.Errorf("index key: %w", err)
+	}
+
+	return p, nil
+}
+
+func decodeKey(encoded string) ([]byte, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, errors.New("must be base64 encoded")
+	}
+	if len(key) != 32 {
+		return nil, errors.New("must be 256 bits long")
+	}
+	return key, nil
+}
+
+func (p *FileKeyProvider) Current() (string, []byte) {
+	return p.current, p.keys[p.current]
+}
+
+func (p *FileKeyProvider) Key(id string) ([]byte, error) {
+	key, ok := p.keys[id]
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownKey, id)
+	}
+	return key, nil
+}
+
+func (p *FileKeyProvider) IndexKey() []byte {
+	return p.index
+}
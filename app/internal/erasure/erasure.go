@@ -0,0 +1,100 @@
+package erasure
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+
+	"github.com/allokate-ai/events/app/internal/encryption"
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// Value that redacted fields are replaced with.
+const Redacted = "[redacted]"
+
+// HashSubject returns the hash by which erasures of a subject are recorded,
+// so that the audit trail can be searched without holding personal data.
+func HashSubject(subject string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(subject))))
+	return hex.EncodeToString(sum[:])
+}
+
+// Redact returns the body of the event with its personal fields, as listed in
+// events.PersonalFields, and any other string mentioning the subject replaced.
+// Encrypted fields are redacted as well and the envelope holding their data
+// key is dropped, so that copies of the body elsewhere can't be decrypted
+// either.
+func Redact(event events.GenericEvent, subject string) (json.RawMessage, error) {
+	if len(event.Body) == 0 {
+		return event.Body, nil
+	}
+
+	var body any
+	if err := json.Unmarshal(event.Body, &body); err != nil {
+		return nil, err
+	}
+
+	if fields, ok := body.(map[string]any); ok {
+		delete(fields, encryption.EnvelopeKey)
+		for _, field := range events.PersonalFields[event.Name] {
+			if _, ok := fields[field]; ok {
+				fields[field] = Redacted
+			}
+		}
+	}
+
+	return json.Marshal(redactValue(body, subject))
+}
+
+// redactValue replaces every string within v that equals the subject,
+// regardless of case, or that is encrypted.
+func redactValue(v any, subject string) any {
+	switch v := v.(type) {
+	case string:
+		if strings.EqualFold(v, subject) || encryption.IsSealed(v) {
+			return Redacted
+		}
+	case map[string]any:
+		for k, item := range v {
+			v[k] = redactValue(item, subject)
+		}
+	case []any:
+		for i, item := range v {
+			v[i] = redactValue(item, subject)
+		}
+	}
+	return v
+}
+
+// Name of the source of tombstone events.
+const Source = "events"
+
+// Tombstones builds the tombstone events announcing that the events were
+// deleted or redacted by an erasure.
+func Tombstones(list []events.GenericEvent, action, erasureId string) ([]events.GenericEvent, error) {
+	tombstones := []events.GenericEvent{}
+	for _, event := range list {
+		body, err := json.Marshal(events.Tombstone{
+			EventId:   event.Id,
+			EventName: event.Name,
+			Action:    action,
+			ErasureId: erasureId,
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		tombstones = append(tombstones, events.GenericEvent{
+			Id:        uuid.New().String(),
+			Timestamp: time.Now().UTC(),
+			Name:      events.TombstonePrefix + event.Name,
+			Source:    Source,
+			Body:      body,
+		})
+	}
+	return tombstones, nil
+}
@@ -0,0 +1,51 @@
+package outbox
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// How long sent entries are kept around before being purged.
+const retention = 24 * time.Hour
+
+// Relay periodically publishes pending outbox entries until the context is
+// cancelled. Entries that fail to publish stay pending and are retried on the
+// next tick, which gives at-least-once delivery to the exchange.
+func Relay(ctx context.Context, pg *sql.DB, interval time.Duration, batchSize int, publish func(events.GenericEvent) error) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	lastPurge := time.Now()
+
+	for {
+		// Keep draining while there are full batches waiting to be sent.
+		for {
+			sent, err := db.RelayOutbox(pg, batchSize, publish)
+			if err != nil {
+				log.Println("outbox relay:", err)
+				break
+			}
+			if sent < batchSize {
+				break
+			}
+		}
+
+		if time.Since(lastPurge) > time.Hour {
+			if err := db.PurgeOutbox(pg, time.Now().Add(-retention)); err != nil {
+				log.Println("outbox purge:", err)
+			}
+			lastPurge = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
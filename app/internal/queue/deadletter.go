@@ -0,0 +1,204 @@
+package queue
+
+import (
+	"encoding/json"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+)
+
+const (
+	// Exchange and queue holding messages that couldn't be processed.
+	DeadLetterExchange = "events.dead-letter"
+	DeadLetterQueue    = "events.dead-letter"
+
+	// Headers describing why and where a message was dead lettered.
+	attemptsHeader   = "x-attempts"
+	exchangeHeader   = "x-original-exchange"
+	routingKeyHeader = "x-original-routing-key"
+	reasonHeader     = "x-dead-letter-reason"
+	queueHeader      = "x-dead-letter-queue"
+	failedAtHeader   = "x-dead-letter-failed-at"
+)
+
+// DeadLetter describes a message held in the dead letter queue.
+type DeadLetter struct {
+	Id         string `json:"id"`
+	Queue      string `json:"queue"`
+	Exchange   string `json:"exchange"`
+	RoutingKey string `json:"routing_key"`
+	Reason     string `json:"reason"`
+	Attempts   int    `json:"attempts"`
+	FailedAt   string `json:"failed_at"`
+
+	// The message body, as JSON if it is valid JSON or as a string otherwise.
+	Body any `json:"body"`
+}
+
+// Attempts returns the number of times a message has been handled, as
+// recorded in its headers.
+func Attempts(headers amqp.Table) int {
+	switch v := headers[attemptsHeader].(type) {
+	case int32:
+		return int(v)
+	case int64:
+		return int(v)
+	case int:
+		return v
+	}
+	return 0
+}
+
+// failureHeaders copies the headers of a message that failed to be handled,
+// bumping its count of attempts and remembering where it was first published
+// to, as retries are republished straight to the queue.
+func failureHeaders(d amqp.Delivery) amqp.Table {
+	headers := amqp.Table{}
+	for k, v := range d.Headers {
+		headers[k] = v
+	}
+	headers[attemptsHeader] = int32(Attempts(d.Headers) + 1)
+	if _, ok := headers[routingKeyHeader]; !ok {
+		headers[exchangeHeader] = d.Exchange
+		headers[routingKeyHeader] = d.RoutingKey
+	}
+	return headers
+}
+
+// deadLetter moves a message that was consumed from the queue into the dead
+// letter queue, recording the reason in its headers.
+func deadLetter(ch *amqp.Channel, queue string, d amqp.Delivery, reason string) error {
+	headers := failureHeaders(d)
+	headers[reasonHeader] = reason
+	headers[queueHeader] = queue
+	headers[failedAtHeader] = time.Now().UTC().Format(time.RFC3339)
+	key, _ := headers[routingKeyHeader].(string)
+
+	// Every dead letter needs an ID to be picked out for re-driving.
+	id := d.MessageId
+	if id == "" {
+		id = uuid.New().String()
+	}
+
+	if err := ch.Publish(
+		DeadLetterExchange, // exchange
+		key,                // routing key
+		false,              // mandatory
+		false,              // immediate
+		amqp.Publishing{
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    id,
+			Headers:      headers,
+			Body:         d.Body,
+		}); err != nil {
+		return err
+	}
+
+	return d.Ack(false)
+}
+
+func describe(d amqp.Delivery) DeadLetter {
+	letter := DeadLetter{
+		Id:       d.MessageId,
+		Attempts: Attempts(d.Headers),
+	}
+	letter.Queue, _ = d.Headers[queueHeader].(string)
+	letter.Exchange, _ = d.Headers[exchangeHeader].(string)
+	letter.RoutingKey, _ = d.Headers[routingKeyHeader].(string)
+	letter.Reason, _ = d.Headers[reasonHeader].(string)
+	letter.FailedAt, _ = d.Headers[failedAtHeader].(string)
+
+	if json.Valid(d.Body) {
+		letter.Body = json.RawMessage(d.Body)
+	} else {
+		letter.Body = string(d.Body)
+	}
+
+	return letter
+}
+
+// PeekDeadLetters returns up to limit messages from the front of the dead
+// letter queue without removing them. The channel should be closed afterwards,
+// which puts the messages back.
+func PeekDeadLetters(ch *amqp.Channel, limit int) ([]DeadLetter, error) {
+	list := []DeadLetter{}
+	for len(list) < limit {
+		d, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return list, err
+		}
+		if !ok {
+			break
+		}
+		list = append(list, describe(d))
+	}
+
+	return list, nil
+}
+
+// RedriveDeadLetters looks through up to limit messages at the front of the
+// dead letter queue and sends those with the given IDs, or all of them if no
+// IDs are given, back to the queue they were dead lettered from with a fresh
+// count of attempts. Each message is only removed from the dead letter queue
+// once publish succeeds. The channel should be closed afterwards, which puts
+// the messages that were passed over back.
+func RedriveDeadLetters(ch *amqp.Channel, ids []string, limit int, publish func(Message) error) ([]DeadLetter, error) {
+	wanted := map[string]bool{}
+	for _, id := range ids {
+		wanted[id] = true
+	}
+
+	redriven := []DeadLetter{}
+	for i := 0; i < limit; i++ {
+		d, ok, err := ch.Get(DeadLetterQueue, false)
+		if err != nil {
+			return redriven, err
+		}
+		if !ok {
+			break
+		}
+
+		letter := describe(d)
+		if len(wanted) > 0 && !wanted[letter.Id] {
+			continue
+		}
+
+		// Messages that don't record the queue they came from go back
+		// through the exchange they were originally published to.
+		exchange, key := "", letter.Queue
+		if key == "" {
+			exchange, key = letter.Exchange, letter.RoutingKey
+		}
+
+		headers := amqp.Table{}
+		for k, v := range d.Headers {
+			headers[k] = v
+		}
+		for _, k := range []string{attemptsHeader, exchangeHeader, routingKeyHeader, reasonHeader, queueHeader, failedAtHeader} {
+			delete(headers, k)
+		}
+
+		if err := publish(Message{
+			Exchange:   exchange,
+			RoutingKey: key,
+			Publishing: amqp.Publishing{
+				ContentType:  d.ContentType,
+				DeliveryMode: amqp.Persistent,
+				MessageId:    d.MessageId,
+				Headers:      headers,
+				Body:         d.Body,
+			},
+		}); err != nil {
+			return redriven, err
+		}
+
+		if err := d.Ack(false); err != nil {
+			return redriven, err
+		}
+		redriven = append(redriven, letter)
+	}
+
+	return redriven, nil
+}
@@ -0,0 +1,463 @@
+package queue
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/streadway/amqp"
+
+	events "github.com/allokate-ai/events/app/pkg/client"
+)
+
+// ErrUnavailable is returned when a message could not be handed to the broker,
+// either because the connection is down or because the broker refused it.
+var ErrUnavailable = errors.New("message broker unavailable")
+
+const (
+	// Delays between reconnection attempts grow from minBackoff to maxBackoff.
+	// The first attempt is made straight away.
+	minBackoff = time.Second
+	maxBackoff = 30 * time.Second
+
+	// The confirmation channel is buffered so that the connection seldom
+	// waits on the confirmations being handed out.
+	confirmBuffer = 256
+)
+
+// Publisher publishes messages in confirm mode over a connection that is
+// re-established automatically, with backoff, whenever it is lost. A channel
+// closed by the broker, such as for publishing to a missing exchange, is
+// reopened on its own without touching the connection or the channels opened
+// on it for consuming.
+type Publisher struct {
+	url string
+
+	// connMu guards the connection, which is also used to open channels
+	// while a publish is in progress. It is taken before mu when both are
+	// needed.
+	connMu sync.Mutex
+	conn   *amqp.Connection
+
+	// mu orders publishing, so that delivery tags are handed out in the
+	// order the broker numbers the messages, and guards the channel. It is
+	// only held while messages are written, not while their confirmations
+	// are awaited.
+	mu sync.Mutex
+	ch *confirmChannel
+
+	done chan struct{}
+}
+
+// confirmChannel is a channel in confirm mode along with the publishes waiting
+// on its confirmations, keyed by delivery tag.
+type confirmChannel struct {
+	ch *amqp.Channel
+
+	mu      sync.Mutex
+	next    uint64
+	waiting map[uint64]*confirmWait
+	closed  bool
+}
+
+// confirmWait collects the confirmations of one call to PublishAll. Until all
+// of its messages are sent, left counts one more so that done isn't closed
+// early.
+type confirmWait struct {
+	cc     *confirmChannel
+	tags   []uint64
+	left   int
+	nacked int
+	lost   bool
+	done   chan struct{}
+}
+
+// NewPublisher connects to the broker and declares the exchanges and queues
+// the service relies on.
+func NewPublisher(host string, port int, username, password string) (*Publisher, error) {
+	p := &Publisher{
+		url:  url(host, port, username, password),
+		done: make(chan struct{}),
+	}
+
+	if err := p.connect(); err != nil {
+		return nil, err
+	}
+
+	return p, nil
+}
+
+func (p *Publisher) connect() error {
+	conn, err := amqp.Dial(p.url)
+	if err != nil {
+		return err
+	}
+
+	// Declare the topology on every connect in case the broker lost it.
+	if err := Init(conn); err != nil {
+		conn.Close()
+		return err
+	}
+
+	cc, err := openConfirmChannel(conn)
+	if err != nil {
+		conn.Close()
+		return err
+	}
+
+	p.connMu.Lock()
+	p.conn = conn
+	p.mu.Lock()
+	p.ch = cc
+	p.mu.Unlock()
+	p.connMu.Unlock()
+
+	go p.watchConnection(conn, conn.NotifyClose(make(chan *amqp.Error, 1)))
+	go p.watchChannel(conn, cc)
+
+	return nil
+}
+
+// openConfirmChannel opens a channel in confirm mode and starts handing out
+// its confirmations.
+func openConfirmChannel(conn *amqp.Connection) (*confirmChannel, error) {
+	ch, err := conn.Channel()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ch.Confirm(false); err != nil {
+		ch.Close()
+		return nil, err
+	}
+
+	// The broker numbers the messages of a channel from 1.
+	cc := &confirmChannel{ch: ch, next: 1, waiting: map[uint64]*confirmWait{}}
+	go cc.dispatch(ch.NotifyPublish(make(chan amqp.Confirmation, confirmBuffer)))
+	return cc, nil
+}
+
+// dispatch hands each confirmation to the publish waiting for it until the
+// channel closes, and then fails the publishes still waiting. Confirmations
+// that no one waits for any more are dropped.
+func (cc *confirmChannel) dispatch(confirms chan amqp.Confirmation) {
+	for confirm := range confirms {
+		cc.mu.Lock()
+		if w, ok := cc.waiting[confirm.DeliveryTag]; ok {
+			delete(cc.waiting, confirm.DeliveryTag)
+			if !confirm.Ack {
+				w.nacked++
+			}
+			if w.left--; w.left == 0 {
+				close(w.done)
+			}
+		}
+		cc.mu.Unlock()
+	}
+
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	cc.closed = true
+	for tag, w := range cc.waiting {
+		delete(cc.waiting, tag)
+		if !w.lost {
+			w.lost = true
+			close(w.done)
+		}
+	}
+}
+
+// release drops the count held on the publish while its messages were sent.
+func (cc *confirmChannel) release(w *confirmWait) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	if w.left--; w.left == 0 && !w.lost {
+		close(w.done)
+	}
+}
+
+// forget stops waiting for the confirmations of the publish.
+func (cc *confirmChannel) forget(w *confirmWait) {
+	cc.mu.Lock()
+	defer cc.mu.Unlock()
+
+	for _, tag := range w.tags {
+		delete(cc.waiting, tag)
+	}
+}
+
+// watchConnection waits for the connection to close and then reconnects.
+func (p *Publisher) watchConnection(conn *amqp.Connection, closed chan *amqp.Error) {
+	var reason *amqp.Error
+	select {
+	case <-p.done:
+		return
+	case reason = <-closed:
+	}
+	log.Println("queue: publisher connection lost:", reason)
+
+	p.connMu.Lock()
+	if p.conn == conn {
+		p.conn = nil
+		p.mu.Lock()
+		p.ch = nil
+		p.mu.Unlock()
+	}
+	p.connMu.Unlock()
+	conn.Close()
+
+	reconnect(p.done, func() bool {
+		if err := p.connect(); err != nil {
+			log.Println("queue: publisher reconnect failed:", err)
+			return false
+		}
+		log.Println("queue: publisher reconnected")
+		return true
+	})
+}
+
+// watchChannel waits for the publishing channel to close and then opens
+// another on the same connection. Channels closing along with the connection
+// are left to watchConnection.
+func (p *Publisher) watchChannel(conn *amqp.Connection, cc *confirmChannel) {
+	var reason *amqp.Error
+	select {
+	case <-p.done:
+		return
+	case reason = <-cc.ch.NotifyClose(make(chan *amqp.Error, 1)):
+	}
+
+	p.mu.Lock()
+	if p.ch == cc {
+		p.ch = nil
+	}
+	p.mu.Unlock()
+
+	if conn.IsClosed() {
+		return
+	}
+	log.Println("queue: publisher channel closed:", reason)
+
+	reconnect(p.done, func() bool {
+		if conn.IsClosed() {
+			return true
+		}
+		next, err := openConfirmChannel(conn)
+		if err != nil {
+			log.Println("queue: publisher channel reopen failed:", err)
+			return false
+		}
+
+		p.connMu.Lock()
+		current := p.conn == conn
+		if current {
+			p.mu.Lock()
+			p.ch = next
+			p.mu.Unlock()
+		}
+		p.connMu.Unlock()
+
+		if !current {
+			next.ch.Close()
+			return true
+		}
+		log.Println("queue: publisher channel reopened")
+		go p.watchChannel(conn, next)
+		return true
+	})
+}
+
+// reconnect calls attempt until it reports success or done is closed, right
+// away and then with a growing backoff.
+func reconnect(done chan struct{}, attempt func() bool) {
+	backoff := minBackoff
+	for {
+		select {
+		case <-done:
+			return
+		default:
+		}
+		if attempt() {
+			return
+		}
+
+		select {
+		case <-done:
+			return
+		case <-time.After(backoff):
+		}
+		if backoff *= 2; backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
+
+// Message is a message to be published to an exchange.
+type Message struct {
+	Exchange   string
+	RoutingKey string
+	Publishing amqp.Publishing
+}
+
+// Publish sends a message to the exchange and waits until the broker confirms
+// it. ErrUnavailable is returned if the broker can't be reached, the message is
+// nacked, or the context expires before a confirmation arrives.
+func (p *Publisher) Publish(ctx context.Context, exchange, key string, msg amqp.Publishing) error {
+	return p.PublishAll(ctx, []Message{{Exchange: exchange, RoutingKey: key, Publishing: msg}})
+}
+
+// PublishAll sends the messages in order and waits until the broker has
+// confirmed all of them. Failures are reported as by Publish; when one occurs
+// some of the messages may nonetheless have been delivered. Other publishes
+// go ahead while this one waits for its confirmations, and giving up on them
+// when the context expires leaves the channel open for the rest.
+func (p *Publisher) PublishAll(ctx context.Context, msgs []Message) error {
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	w, err := p.send(msgs)
+	if w == nil {
+		return err
+	}
+
+	select {
+	case <-w.done:
+	case <-ctx.Done():
+		w.cc.forget(w)
+		return fmt.Errorf("%w: %s", ErrUnavailable, ctx.Err())
+	}
+
+	if err != nil {
+		return err
+	}
+	if w.lost {
+		return fmt.Errorf("%w: channel closed before confirmation", ErrUnavailable)
+	}
+	if w.nacked > 0 {
+		return fmt.Errorf("%w: %d of %d messages were nacked", ErrUnavailable, w.nacked, len(msgs))
+	}
+
+	return nil
+}
+
+// send writes the messages to the channel, registering each delivery tag
+// before the message goes out so that its confirmation can't be missed. It
+// returns what to wait on for the messages that were sent, if any, along with
+// the error that stopped the rest.
+func (p *Publisher) send(msgs []Message) (*confirmWait, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	cc := p.ch
+	if cc == nil {
+		return nil, ErrUnavailable
+	}
+
+	w := &confirmWait{cc: cc, left: 1, done: make(chan struct{})}
+	defer cc.release(w)
+
+	for _, msg := range msgs {
+		cc.mu.Lock()
+		if cc.closed {
+			cc.mu.Unlock()
+			return w.sent(fmt.Errorf("%w: channel closed", ErrUnavailable))
+		}
+		tag := cc.next
+		cc.waiting[tag] = w
+		w.tags = append(w.tags, tag)
+		w.left++
+		cc.mu.Unlock()
+
+		if err := cc.ch.Publish(
+			msg.Exchange,   // exchange
+			msg.RoutingKey, // routing key
+			false,          // mandatory
+			false,          // immediate
+			msg.Publishing,
+		); err != nil {
+			// The message wasn't numbered, so neither are those after it.
+			cc.mu.Lock()
+			delete(cc.waiting, tag)
+			w.tags = w.tags[:len(w.tags)-1]
+			w.left--
+			cc.mu.Unlock()
+			return w.sent(fmt.Errorf("%w: %s", ErrUnavailable, err))
+		}
+		cc.next++
+	}
+
+	return w, nil
+}
+
+// sent returns what to wait on for a publish cut short by err: nothing if
+// none of its messages went out.
+func (w *confirmWait) sent(err error) (*confirmWait, error) {
+	if len(w.tags) == 0 {
+		return nil, err
+	}
+	return w, err
+}
+
+// Enqueue publishes the event to the events exchange, routed by its name.
+func (p *Publisher) Enqueue(ctx context.Context, event events.GenericEvent) error {
+	return p.EnqueueAll(ctx, []events.GenericEvent{event})
+}
+
+// EnqueueAll publishes the events to the events exchange, routed by their
+// names, and waits until the broker has confirmed all of them.
+func (p *Publisher) EnqueueAll(ctx context.Context, list []events.GenericEvent) error {
+	msgs := make([]Message, 0, len(list))
+	for _, event := range list {
+		data, err := json.Marshal(event)
+		if err != nil {
+			return err
+		}
+
+		msgs = append(msgs, Message{
+			Exchange:   "events",
+			RoutingKey: event.Name,
+			Publishing: amqp.Publishing{
+				ContentType:  "application/json",
+				DeliveryMode: amqp.Persistent,
+				MessageId:    event.Id,
+				Body:         data,
+			},
+		})
+	}
+
+	return p.PublishAll(ctx, msgs)
+}
+
+// Channel opens a new channel on the current connection, for consuming or for
+// other work that shouldn't interfere with publishing. The caller is
+// responsible for closing it.
+func (p *Publisher) Channel() (*amqp.Channel, error) {
+	p.connMu.Lock()
+	conn := p.conn
+	p.connMu.Unlock()
+
+	if conn == nil {
+		return nil, ErrUnavailable
+	}
+	return conn.Channel()
+}
+
+// Close stops reconnecting and closes the connection to the broker.
+func (p *Publisher) Close() error {
+	close(p.done)
+
+	p.connMu.Lock()
+	defer p.connMu.Unlock()
+
+	if p.conn == nil {
+		return nil
+	}
+	return p.conn.Close()
+}
@@ -3,17 +3,22 @@ package queue
 import (
 	"encoding/json"
 	"fmt"
+	"time"
 
 	"github.com/streadway/amqp"
 
 	events "github.com/allokate-ai/events/app/pkg/client"
 )
 
-func Connect(host string, port int, username, password string) (*amqp.Connection, error) {
+func url(host string, port int, username, password string) string {
 	if username != "" && password != "" {
-		return amqp.Dial(fmt.Sprintf("amqp://%s:%s@%s:%d/", username, password, host, port))
+		return fmt.Sprintf("amqp://%s:%s@%s:%d/", username, password, host, port)
 	}
-	return amqp.Dial(fmt.Sprintf("amqp://%s:%d/", host, port))
+	return fmt.Sprintf("amqp://%s:%d/", host, port)
+}
+
+func Connect(host string, port int, username, password string) (*amqp.Connection, error) {
+	return amqp.Dial(url(host, port, username, password))
 }
 
 func Init(conn *amqp.Connection) error {
@@ -21,6 +26,7 @@ func Init(conn *amqp.Connection) error {
 	if err != nil {
 		return err
 	}
+	defer ch.Close()
 
 	if err := ch.ExchangeDeclare(
 		"events", // name
@@ -55,38 +61,165 @@ func Init(conn *amqp.Connection) error {
 		return err
 	}
 
+	// Messages that can't be processed are set aside in the dead letter
+	// queue, routed by their original routing key.
+	if err := DeclareExchange(ch, DeadLetterExchange); err != nil {
+		return err
+	}
+
+	if _, err := ch.QueueDeclare(
+		DeadLetterQueue, // name
+		true,            // durable
+		false,           // delete when unused
+		false,           // exclusive
+		false,           // no-wait
+		nil,             // arguments
+	); err != nil {
+		return err
+	}
+
+	if err := ch.QueueBind(
+		DeadLetterQueue,    // name
+		"#",                // key
+		DeadLetterExchange, // exchange
+		false,              // no-wait
+		nil,                // arguments
+	); err != nil {
+		return err
+	}
+
 	return nil
 }
 
-func Enqueue(ch *amqp.Channel, event events.GenericEvent) error {
-	data, err := json.Marshal(event)
+// DeclareExchange declares a durable topic exchange like the events exchange.
+func DeclareExchange(ch *amqp.Channel, name string) error {
+	return ch.ExchangeDeclare(
+		name,    // name
+		"topic", // kind
+		true,    // durable
+		false,   // auto delete
+		false,   // internal
+		false,   // no-wait
+		nil,     // arguments
+	)
+}
+
+// Subscribe declares a server-named, exclusive queue that is deleted along with
+// the channel, binds it to the events exchange under each of the routing key
+// patterns and starts consuming from it without acknowledgements. The name of
+// the queue is returned so that bindings can be changed later on.
+func Subscribe(ch *amqp.Channel, keys []string) (string, <-chan amqp.Delivery, error) {
+	q, err := ch.QueueDeclare(
+		"",    // name
+		false, // durable
+		true,  // delete when unused
+		true,  // exclusive
+		false, // no-wait
+		nil,   // arguments
+	)
 	if err != nil {
-		return err
+		return "", nil, err
 	}
 
-	if err = ch.Publish(
-		"events",   // exchange
-		event.Name, // routing key
-		false,      // mandatory
-		false,      // immediate
-		amqp.Publishing{
-			ContentType: "application/json",
-			Body:        data,
-		}); err != nil {
-		return err
+	for _, key := range keys {
+		if err := ch.QueueBind(
+			q.Name,   // name
+			key,      // key
+			"events", // exchange
+			false,    // no-wait
+			nil,      // arguments
+		); err != nil {
+			return "", nil, err
+		}
+	}
+
+	deliveries, err := ch.Consume(
+		q.Name, // name
+		"",     // consumerTag,
+		true,   // noAck
+		true,   // exclusive
+		false,  // noLocal
+		false,  // noWait
+		nil,    // arguments
+	)
+	if err != nil {
+		return "", nil, err
+	}
+
+	return q.Name, deliveries, nil
+}
+
+// Options for consuming events from a queue.
+type ConsumeOptions struct {
+	// Name of the queue to consume from.
+	Queue string
+
+	// Number of times a message is handed to the handler before it is given
+	// up on and dead lettered.
+	MaxAttempts int
+
+	// How long to wait before each retry. The last delay is used for any
+	// further retries. Without delays messages are retried straight away.
+	RetryDelays []time.Duration
+
+	// Largest number of events handed to a batch handler at once, which is
+	// also the number of unacknowledged messages the broker sends ahead.
+	BatchSize int
+
+	// Longest time a partial batch waits for more events before being
+	// handled.
+	BatchTimeout time.Duration
+}
+
+// retryQueue names the queue that parks messages for the given delay before
+// sending them back to the queue.
+func retryQueue(queue string, delay time.Duration) string {
+	return fmt.Sprintf("%s.retry.%dms", queue, delay.Milliseconds())
+}
+
+// declareRetryQueues declares a durable queue for each of the retry delays in
+// which messages wait out the delay before being dead lettered back to the
+// queue through the default exchange.
+func declareRetryQueues(ch *amqp.Channel, opts ConsumeOptions) error {
+	for _, delay := range opts.RetryDelays {
+		args := amqp.Table{
+			"x-message-ttl":             delay.Milliseconds(),
+			"x-dead-letter-exchange":    "",
+			"x-dead-letter-routing-key": opts.Queue,
+		}
+
+		if _, err := ch.QueueDeclare(
+			retryQueue(opts.Queue, delay), // name
+			true,                          // durable
+			false,                         // delete when unused
+			false,                         // exclusive
+			false,                         // no-wait
+			args,                          // arguments
+		); err != nil {
+			return err
+		}
 	}
 	return nil
 }
 
-func Consume(ch *amqp.Channel, handler func(*events.GenericEvent) bool) error {
+// Consume hands every event delivered to the queue to the handler until the
+// channel is closed. When the handler fails the event is parked in a retry
+// queue and tried again once the delay is up, up to the maximum number of
+// attempts, after which it is dead lettered along with the reason it failed.
+// Messages that aren't valid events are dead lettered straight away.
+func Consume(ch *amqp.Channel, opts ConsumeOptions, handler func(*events.GenericEvent) error) error {
+	if err := declareRetryQueues(ch, opts); err != nil {
+		return err
+	}
+
 	deliveries, err := ch.Consume(
-		"log", // name
-		"",    // consumerTag,
-		false, // noAck
-		false, // exclusive
-		false, // noLocal
-		false, // noWait
-		nil,   // arguments
+		opts.Queue, // name
+		"",         // consumerTag,
+		false,      // noAck
+		false,      // exclusive
+		false,      // noLocal
+		false,      // noWait
+		nil,        // arguments
 	)
 	if err != nil {
 		return err
@@ -95,16 +228,169 @@ func Consume(ch *amqp.Channel, handler func(*events.GenericEvent) bool) error {
 	for d := range deliveries {
 		var event events.GenericEvent
 		if err := json.Unmarshal(d.Body, &event); err != nil {
-			return err
+			if err := deadLetter(ch, opts.Queue, d, fmt.Sprintf("malformed event: %s", err)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if err := handler(&event); err != nil {
+			if err := retry(ch, opts, d, err); err != nil {
+				return err
+			}
+			continue
 		}
 
 		// If handled successfully acknowledge the message.
-		if handler(&event) {
-			d.Ack(true)
-		} else {
-			d.Ack(false)
+		if err := d.Ack(false); err != nil {
+			return err
 		}
 	}
 
 	return nil
 }
+
+// retry parks a message the handler failed on in the retry queue for its
+// attempt, or puts it straight back on the queue if there are no delays, with
+// its attempt count bumped. It is dead lettered once it has run out of
+// attempts. Messages are republished rather than nacked since a requeued
+// message can't carry a count of its attempts.
+func retry(ch *amqp.Channel, opts ConsumeOptions, d amqp.Delivery, cause error) error {
+	attempts := Attempts(d.Headers) + 1
+	if attempts >= opts.MaxAttempts {
+		return deadLetter(ch, opts.Queue, d, fmt.Sprintf("failed after %d attempts: %s", attempts, cause))
+	}
+	headers := failureHeaders(d)
+
+	key := opts.Queue
+	if len(opts.RetryDelays) > 0 {
+		i := attempts - 1
+		if i >= len(opts.RetryDelays) {
+			i = len(opts.RetryDelays) - 1
+		}
+		key = retryQueue(opts.Queue, opts.RetryDelays[i])
+	}
+
+	if err := ch.Publish(
+		"",    // exchange
+		key,   // routing key
+		false, // mandatory
+		false, // immediate
+		amqp.Publishing{
+			ContentType:  d.ContentType,
+			DeliveryMode: amqp.Persistent,
+			MessageId:    d.MessageId,
+			Headers:      headers,
+			Body:         d.Body,
+		}); err != nil {
+		return err
+	}
+
+	return d.Ack(false)
+}
+
+// ConsumeBatch is like Consume but hands events to the handler in batches of
+// up to opts.BatchSize, acknowledging each batch in one go once the handler
+// succeeds. When a batch fails its events are handed to the handler one at a
+// time so that a single bad event can't hold back the rest, and those that
+// still fail are retried like they are by Consume.
+func ConsumeBatch(ch *amqp.Channel, opts ConsumeOptions, handler func([]events.GenericEvent) error) error {
+	if err := declareRetryQueues(ch, opts); err != nil {
+		return err
+	}
+
+	if opts.BatchSize < 1 {
+		opts.BatchSize = 1
+	}
+	if err := ch.Qos(opts.BatchSize, 0, false); err != nil {
+		return err
+	}
+
+	deliveries, err := ch.Consume(
+		opts.Queue, // name
+		"",         // consumerTag,
+		false,      // noAck
+		false,      // exclusive
+		false,      // noLocal
+		false,      // noWait
+		nil,        // arguments
+	)
+	if err != nil {
+		return err
+	}
+
+	batch := []amqp.Delivery{}
+	list := []events.GenericEvent{}
+
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		defer func() {
+			batch = batch[:0]
+			list = list[:0]
+		}()
+
+		if err := handler(list); err == nil {
+			// Acknowledge every message up to and including the last one.
+			return batch[len(batch)-1].Ack(true)
+		}
+
+		for i, d := range batch {
+			if err := handler(list[i : i+1]); err != nil {
+				if err := retry(ch, opts, d, err); err != nil {
+					return err
+				}
+				continue
+			}
+			if err := d.Ack(false); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	// The timer only runs while a partial batch is waiting.
+	timer := time.NewTimer(opts.BatchTimeout)
+	if !timer.Stop() {
+		<-timer.C
+	}
+
+	for {
+		select {
+		case d, ok := <-deliveries:
+			if !ok {
+				return flush()
+			}
+
+			var event events.GenericEvent
+			if err := json.Unmarshal(d.Body, &event); err != nil {
+				if err := deadLetter(ch, opts.Queue, d, fmt.Sprintf("malformed event: %s", err)); err != nil {
+					return err
+				}
+				continue
+			}
+
+			batch = append(batch, d)
+			list = append(list, event)
+			if len(batch) == 1 {
+				timer.Reset(opts.BatchTimeout)
+			}
+			if len(batch) < opts.BatchSize {
+				continue
+			}
+
+			if !timer.Stop() {
+				<-timer.C
+			}
+			if err := flush(); err != nil {
+				return err
+			}
+
+		case <-timer.C:
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+}
@@ -0,0 +1,37 @@
+package queue
+
+import "strings"
+
+// MatchTopic reports whether the routing key matches the pattern using the
+// rules of a topic exchange: words are separated by dots, "*" matches exactly
+// one word and "#" matches zero or more words.
+func MatchTopic(pattern, key string) bool {
+	return matchWords(strings.Split(pattern, "."), strings.Split(key, "."))
+}
+
+func matchWords(pattern, key []string) bool {
+	for len(pattern) > 0 {
+		switch pattern[0] {
+		case "#":
+			// Try every possible number of words for the hash to absorb.
+			for i := 0; i <= len(key); i++ {
+				if matchWords(pattern[1:], key[i:]) {
+					return true
+				}
+			}
+			return false
+		case "*":
+			if len(key) == 0 {
+				return false
+			}
+		default:
+			if len(key) == 0 || pattern[0] != key[0] {
+				return false
+			}
+		}
+		pattern = pattern[1:]
+		key = key[1:]
+	}
+
+	return len(key) == 0
+}
@@ -0,0 +1,59 @@
+package queue
+
+import "testing"
+
+func TestMatchTopic(t *testing.T) {
+	tests := []struct {
+		pattern string
+		key     string
+		match   bool
+	}{
+		{"article.scraped", "article.scraped", true},
+		{"article.scraped", "article.published", false},
+		{"article.scraped", "article", false},
+		{"article", "article.scraped", false},
+
+		{"*", "tweet", true},
+		{"*", "article.scraped", false},
+		{"article.*", "article.scraped", true},
+		{"article.*", "article", false},
+		{"*.scraped", "article.scraped", true},
+		{"article.*.done", "article.scraped.done", true},
+		{"article.*.done", "article.done", false},
+
+		{"#", "tweet", true},
+		{"#", "article.scraped", true},
+		{"#", "", true},
+
+		// # at the start.
+		{"#.scraped", "scraped", true},
+		{"#.scraped", "article.scraped", true},
+		{"#.scraped", "news.article.scraped", true},
+		{"#.scraped", "article.scraped.done", false},
+
+		// # in the middle.
+		{"article.#.done", "article.done", true},
+		{"article.#.done", "article.scraped.done", true},
+		{"article.#.done", "article.scraped.twice.done", true},
+		{"article.#.done", "article.scraped", false},
+		{"article.#.done", "tweet.done", false},
+
+		// # at the end.
+		{"user.#", "user", true},
+		{"user.#", "user.invite", true},
+		{"user.#", "user.invite.sent", true},
+		{"user.#", "users.invite", false},
+		{"user.#", "login", false},
+
+		{"#.*", "tweet", true},
+		{"#.*", "", true},
+		{"*.#.*", "tweet", false},
+		{"#.#", "article.scraped", true},
+	}
+
+	for _, test := range tests {
+		if got := MatchTopic(test.pattern, test.key); got != test.match {
+			t.Errorf("MatchTopic(%q, %q): expected %v, got %v", test.pattern, test.key, test.match, got)
+		}
+	}
+}
@@ -0,0 +1,216 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/queue"
+)
+
+// Rule limits how fast events with a name may be published by a single
+// producer. Zero values leave the respective limit off.
+type Rule struct {
+	// Topic pattern of the event names the rule applies to.
+	Name string `json:"name"`
+
+	// Events per second let through on average.
+	Rate float64 `json:"rate"`
+
+	// Events let through at once after a lull. Defaults to one second's
+	// worth of events.
+	Burst int `json:"burst"`
+
+	// Events let through per UTC day.
+	Daily int64 `json:"daily"`
+}
+
+// Limits holds the rules for every event name. The first rule whose pattern
+// matches an event's name applies, or the default rule if none does:
+//
+//	{
+//	  "default": {"rate": 50, "daily": 1000000},
+//	  "names": [
+//	    {"name": "article.scraped", "rate": 5, "burst": 20, "daily": 50000}
+//	  ]
+//	}
+type Limits struct {
+	Default Rule   `json:"default"`
+	Names   []Rule `json:"names"`
+}
+
+// Load reads the limits from a JSON file.
+func Load(path string) (Limits, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return Limits{}, err
+	}
+
+	var limits Limits
+	if err := json.Unmarshal(data, &limits); err != nil {
+		return Limits{}, fmt.Errorf("malformed rate limits file: %w", err)
+	}
+
+	for _, rule := range append([]Rule{limits.Default}, limits.Names...) {
+		if rule.Rate < 0 || rule.Burst < 0 || rule.Daily < 0 {
+			return Limits{}, fmt.Errorf("rate limit for %q must not be negative", rule.Name)
+		}
+	}
+
+	return limits, nil
+}
+
+// DefaultPattern is the name the default rule goes by, matching every event.
+const DefaultPattern = "#"
+
+// For returns the rule applying to events with the given name.
+func (l Limits) For(name string) Rule {
+	for _, rule := range l.Names {
+		if queue.MatchTopic(rule.Name, name) {
+			return rule
+		}
+	}
+	return l.defaultRule()
+}
+
+// Rule returns the rule with the given pattern, or the default rule if there
+// is none.
+func (l Limits) Rule(pattern string) Rule {
+	for _, rule := range l.Names {
+		if rule.Name == pattern {
+			return rule
+		}
+	}
+	return l.defaultRule()
+}
+
+func (l Limits) defaultRule() Rule {
+	rule := l.Default
+	rule.Name = DefaultPattern
+	return rule
+}
+
+// Usage identifies what is being limited: the events under a rule published by
+// a producer, which is either an API key or a source. Events are counted
+// against the pattern of the rule applying to them, so that all the names it
+// matches share the same limits.
+type Usage struct {
+	Subject string
+	Pattern string
+}
+
+// Limiter enforces the rates of the limits with a token bucket for every
+// usage. Daily quotas are tracked in the database instead, so that they hold
+// across restarts and servers.
+type Limiter struct {
+	Limits Limits
+
+	mu      sync.Mutex
+	buckets map[Usage]*bucket
+}
+
+type bucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+func NewLimiter(limits Limits) *Limiter {
+	return &Limiter{
+		Limits:  limits,
+		buckets: map[Usage]*bucket{},
+	}
+}
+
+// burst returns the capacity of the buckets of the rule.
+func burst(rule Rule) float64 {
+	if rule.Burst > 0 {
+		return float64(rule.Burst)
+	}
+	return math.Max(1, math.Ceil(rule.Rate))
+}
+
+// Take takes tokens for the given number of events of each usage, either all
+// of them or none. If there aren't enough it returns how long to wait before
+// trying again. Requests larger than a bucket's capacity are let through once
+// the bucket is full, leaving it in debt.
+func (l *Limiter) Take(counts map[Usage]int, now time.Time) (time.Duration, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	wait := time.Duration(0)
+	for usage, n := range counts {
+		rule := l.Limits.Rule(usage.Pattern)
+		if rule.Rate == 0 {
+			continue
+		}
+
+		b := l.refill(usage, rule, now)
+		need := math.Min(float64(n), burst(rule))
+		if b.tokens < need {
+			d := time.Duration((need - b.tokens) / rule.Rate * float64(time.Second))
+			if d > wait {
+				wait = d
+			}
+		}
+	}
+	if wait > 0 {
+		return wait, false
+	}
+
+	for usage, n := range counts {
+		if rule := l.Limits.Rule(usage.Pattern); rule.Rate > 0 {
+			l.buckets[usage].tokens -= float64(n)
+		}
+	}
+	return 0, true
+}
+
+// Give returns the tokens taken for events that weren't published after all,
+// such as for exceeding a daily quota.
+func (l *Limiter) Give(counts map[Usage]int, now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for usage, n := range counts {
+		rule := l.Limits.Rule(usage.Pattern)
+		if rule.Rate == 0 {
+			continue
+		}
+
+		b := l.refill(usage, rule, now)
+		b.tokens = math.Min(burst(rule), b.tokens+float64(n))
+	}
+}
+
+// refill tops up the bucket of the usage for the time passed since it was last
+// used, creating it full if need be.
+func (l *Limiter) refill(usage Usage, rule Rule, now time.Time) *bucket {
+	b, ok := l.buckets[usage]
+	if !ok {
+		b = &bucket{tokens: burst(rule), updated: now}
+		l.buckets[usage] = b
+	}
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(burst(rule), b.tokens+elapsed*rule.Rate)
+		b.updated = now
+	}
+	return b
+}
+
+// Sweep forgets the buckets that have filled up again, which are no different
+// from new ones.
+func (l *Limiter) Sweep(now time.Time) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for usage, b := range l.buckets {
+		rule := l.Limits.Rule(usage.Pattern)
+		if rule.Rate == 0 || b.tokens+now.Sub(b.updated).Seconds()*rule.Rate >= burst(rule) {
+			delete(l.buckets, usage)
+		}
+	}
+}
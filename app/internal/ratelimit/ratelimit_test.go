@@ -0,0 +1,158 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+var testLimits = Limits{
+	Default: Rule{Rate: 10},
+	Names: []Rule{
+		{Name: "article.scraped", Rate: 1, Burst: 5, Daily: 100},
+		{Name: "article.#", Rate: 2},
+		{Name: "tweet", Daily: 1000},
+	},
+}
+
+func TestLimitsFor(t *testing.T) {
+	tests := []struct {
+		name    string
+		pattern string
+	}{
+		// The first matching rule wins, even if a later one also matches.
+		{"article.scraped", "article.scraped"},
+		{"article.published", "article.#"},
+		{"article", "article.#"},
+		{"tweet", "tweet"},
+		{"tweet.deleted", DefaultPattern},
+		{"login", DefaultPattern},
+	}
+
+	for _, test := range tests {
+		if got := testLimits.For(test.name); got.Name != test.pattern {
+			t.Errorf("For(%q): expected the %s rule, got %s", test.name, test.pattern, got.Name)
+		}
+	}
+
+	if rule := testLimits.For("login"); rule.Rate != 10 {
+		t.Errorf("expected the default rule, got %+v", rule)
+	}
+	if rule := testLimits.Rule(DefaultPattern); rule.Rate != 10 {
+		t.Errorf("expected the default rule by its pattern, got %+v", rule)
+	}
+	if rule := testLimits.Rule("article.#"); rule.Rate != 2 {
+		t.Errorf("expected the article.# rule, got %+v", rule)
+	}
+}
+
+func TestTakeAllOrNothing(t *testing.T) {
+	l := NewLimiter(testLimits)
+	now := time.Now()
+
+	scraped := Usage{Subject: "key:a", Pattern: "article.scraped"}
+	other := Usage{Subject: "key:a", Pattern: DefaultPattern}
+
+	if _, ok := l.Take(map[Usage]int{scraped: 4}, now); !ok {
+		t.Fatal("expected the first request to be let through")
+	}
+
+	// Only one scraped token is left, so the whole request is turned down
+	// and the default bucket is left untouched.
+	wait, ok := l.Take(map[Usage]int{scraped: 2, other: 10}, now)
+	if ok {
+		t.Fatal("expected the request to be turned down")
+	}
+	if wait != time.Second {
+		t.Errorf("expected to wait 1s for the missing token, got %v", wait)
+	}
+	if tokens := l.buckets[other].tokens; tokens != 10 {
+		t.Errorf("expected the default bucket to keep its 10 tokens, got %v", tokens)
+	}
+
+	if _, ok := l.Take(map[Usage]int{scraped: 1, other: 10}, now); !ok {
+		t.Fatal("expected the request to be let through")
+	}
+	if l.buckets[scraped].tokens != 0 || l.buckets[other].tokens != 0 {
+		t.Errorf("expected both buckets to be empty, got %v and %v", l.buckets[scraped].tokens, l.buckets[other].tokens)
+	}
+
+	// Usages without a rate aren't limited.
+	if _, ok := l.Take(map[Usage]int{{Subject: "key:a", Pattern: "tweet"}: 1000}, now); !ok {
+		t.Error("expected a usage without a rate to be let through")
+	}
+}
+
+func TestTakeOversize(t *testing.T) {
+	l := NewLimiter(testLimits)
+	now := time.Now()
+	scraped := Usage{Subject: "key:a", Pattern: "article.scraped"}
+
+	// More than the burst of 5 is let through once the bucket is full...
+	if _, ok := l.Take(map[Usage]int{scraped: 8}, now); !ok {
+		t.Fatal("expected an oversize request to be let through by a full bucket")
+	}
+	if tokens := l.buckets[scraped].tokens; tokens != -3 {
+		t.Errorf("expected the bucket to be 3 tokens in debt, got %v", tokens)
+	}
+
+	// ...leaving the bucket in debt until the rate pays it off.
+	wait, ok := l.Take(map[Usage]int{scraped: 1}, now.Add(time.Second))
+	if ok {
+		t.Fatal("expected the bucket in debt to turn the request down")
+	}
+	if wait != 3*time.Second {
+		t.Errorf("expected to wait 3s, got %v", wait)
+	}
+	if _, ok := l.Take(map[Usage]int{scraped: 1}, now.Add(4*time.Second)); !ok {
+		t.Error("expected the request to be let through once the debt is paid off")
+	}
+}
+
+func TestGive(t *testing.T) {
+	l := NewLimiter(testLimits)
+	now := time.Now()
+	scraped := Usage{Subject: "key:a", Pattern: "article.scraped"}
+
+	if _, ok := l.Take(map[Usage]int{scraped: 3}, now); !ok {
+		t.Fatal("expected the request to be let through")
+	}
+	l.Give(map[Usage]int{scraped: 2}, now)
+	if tokens := l.buckets[scraped].tokens; tokens != 4 {
+		t.Errorf("expected 4 tokens after giving 2 back, got %v", tokens)
+	}
+
+	// The bucket never holds more than its burst.
+	l.Give(map[Usage]int{scraped: 10}, now)
+	if tokens := l.buckets[scraped].tokens; tokens != 5 {
+		t.Errorf("expected the bucket to be capped at 5 tokens, got %v", tokens)
+	}
+}
+
+func TestSweep(t *testing.T) {
+	l := NewLimiter(testLimits)
+	now := time.Now()
+
+	full := Usage{Subject: "key:a", Pattern: "article.scraped"}
+	refilled := Usage{Subject: "key:b", Pattern: "article.scraped"}
+	draining := Usage{Subject: "key:c", Pattern: "article.scraped"}
+
+	if _, ok := l.Take(map[Usage]int{full: 0, refilled: 2}, now); !ok {
+		t.Fatal("expected the request to be let through")
+	}
+	if _, ok := l.Take(map[Usage]int{draining: 5}, now.Add(time.Second)); !ok {
+		t.Fatal("expected the request to be let through")
+	}
+
+	// Two seconds on, key:b has its 2 tokens back but key:c only 1 of 5.
+	l.Sweep(now.Add(2 * time.Second))
+
+	if _, ok := l.buckets[full]; ok {
+		t.Error("expected the full bucket to be dropped")
+	}
+	if _, ok := l.buckets[refilled]; ok {
+		t.Error("expected the refilled bucket to be dropped")
+	}
+	if _, ok := l.buckets[draining]; !ok {
+		t.Error("expected the bucket still refilling to be kept")
+	}
+}
@@ -0,0 +1,310 @@
+package replay
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/streadway/amqp"
+
+	"github.com/allokate-ai/events/app/internal/db"
+	"github.com/allokate-ai/events/app/internal/queue"
+)
+
+const (
+	// Number of events read from the database and published at a time.
+	pageSize = 100
+
+	// Number of times a page is published before giving up on the replay
+	// when the broker is unavailable.
+	maxAttempts = 5
+
+	// How long to wait for the broker to confirm a page of events.
+	publishTimeout = 30 * time.Second
+
+	// How long a replay stays with the process running it without being
+	// renewed, and how often the process renews it.
+	leaseDuration = time.Minute
+	leaseRenewal  = leaseDuration / 3
+)
+
+// Runner runs replays in the background, one goroutine per replay. Every
+// replay is run by a single runner at a time, across server instances, which
+// holds its lease for as long as it runs it.
+type Runner struct {
+	pg        *sql.DB
+	publisher *queue.Publisher
+	owner     string
+
+	mu      sync.Mutex
+	cancels map[string]context.CancelFunc
+}
+
+func NewRunner(pg *sql.DB, publisher *queue.Publisher) *Runner {
+	return &Runner{
+		pg:        pg,
+		publisher: publisher,
+		owner:     uuid.New().String(),
+		cancels:   map[string]context.CancelFunc{},
+	}
+}
+
+// Start runs the replay in the background, unless another runner has already
+// taken it on.
+func (r *Runner) Start(job db.Replay) error {
+	job, claimed, err := db.ClaimReplay(r.pg, job.Id, r.owner, leaseDuration)
+	if err != nil || !claimed {
+		return err
+	}
+	r.start(job)
+	return nil
+}
+
+func (r *Runner) start(job db.Replay) {
+	ctx, cancel := context.WithCancel(context.Background())
+
+	r.mu.Lock()
+	r.cancels[job.Id] = cancel
+	r.mu.Unlock()
+
+	go func() {
+		defer func() {
+			r.mu.Lock()
+			delete(r.cancels, job.Id)
+			r.mu.Unlock()
+			cancel()
+		}()
+
+		go r.renew(ctx, cancel, job.Id)
+		r.run(ctx, job)
+	}()
+}
+
+// renew keeps renewing the lease of the replay until the context is done. The
+// replay is stopped if the lease is lost or the replay was cancelled by
+// another server instance.
+func (r *Runner) renew(ctx context.Context, cancel context.CancelFunc, id string) {
+	ticker := time.NewTicker(leaseRenewal)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		err := db.RenewReplayLease(r.pg, id, r.owner, leaseDuration)
+		if errors.Is(err, db.ErrReplayLost) {
+			log.Printf("replay %s: stopping, as it was cancelled or taken over", id)
+			cancel()
+			return
+		}
+		if err != nil {
+			log.Printf("replay %s: %s", id, err)
+		}
+	}
+}
+
+// Resume restarts the replays that no runner holds, such as those interrupted
+// when a server stopped. They pick up after the last event they published.
+func (r *Runner) Resume() error {
+	jobs, err := db.ClaimReplays(r.pg, r.owner, leaseDuration)
+	if err != nil {
+		return err
+	}
+
+	for _, job := range jobs {
+		log.Printf("replay %s: resuming after %d events", job.Id, job.Published)
+		r.start(job)
+	}
+	return nil
+}
+
+// ResumeEvery calls Resume at the interval until the context is done, so that
+// replays left behind by a server instance that went away are picked up.
+func (r *Runner) ResumeEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+
+		if err := r.Resume(); err != nil {
+			log.Println(err)
+		}
+	}
+}
+
+// Cancel stops the replay if it is running in this process.
+func (r *Runner) Cancel(id string) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	cancel, ok := r.cancels[id]
+	if ok {
+		cancel()
+	}
+	return ok
+}
+
+func (r *Runner) run(ctx context.Context, job db.Replay) {
+	err := r.replay(ctx, &job)
+	switch {
+	case errors.Is(err, db.ErrReplayLost):
+		// It was cancelled or is run elsewhere, which has the last word.
+		return
+	case err == nil:
+		job.Status = db.ReplayCompleted
+	case ctx.Err() != nil:
+		job.Status = db.ReplayCancelled
+	default:
+		log.Printf("replay %s: %s", job.Id, err)
+		job.Status = db.ReplayFailed
+		job.Error = err.Error()
+	}
+
+	if err := db.UpdateReplay(r.pg, job, r.owner, leaseDuration); err != nil && !errors.Is(err, db.ErrReplayLost) {
+		log.Printf("replay %s: %s", job.Id, err)
+	}
+}
+
+func (r *Runner) replay(ctx context.Context, job *db.Replay) error {
+	var cursor *db.Cursor
+	if job.Cursor != "" {
+		c, err := db.ParseCursor(job.Cursor)
+		if err != nil {
+			return err
+		}
+		cursor = &c
+	}
+
+	if job.Exchange != "events" {
+		if err := r.declareExchange(job.Exchange); err != nil {
+			return err
+		}
+	}
+
+	job.Status = db.ReplayRunning
+	if err := db.UpdateReplay(r.pg, *job, r.owner, leaseDuration); err != nil {
+		return err
+	}
+
+	limit := pageSize
+	if job.Rate > 0 && job.Rate < limit {
+		limit = job.Rate
+	}
+
+	// The rate is enforced by pacing pages against the time this run started.
+	started := time.Now()
+	sent := 0
+
+	for {
+		list, next, err := db.ListEventsOldestFirst(r.pg, job.Filter, limit, cursor)
+		if err != nil {
+			return err
+		}
+		if len(list) == 0 {
+			return nil
+		}
+
+		msgs := make([]queue.Message, 0, len(list))
+		for _, event := range list {
+			data, err := json.Marshal(event)
+			if err != nil {
+				return err
+			}
+
+			key := job.RoutingKey
+			if key == "" {
+				key = event.Name
+			}
+
+			msgs = append(msgs, queue.Message{
+				Exchange:   job.Exchange,
+				RoutingKey: key,
+				Publishing: amqp.Publishing{
+					ContentType:  "application/json",
+					DeliveryMode: amqp.Persistent,
+					MessageId:    event.Id,
+					Headers: amqp.Table{
+						"replayed":  true,
+						"replay_id": job.Id,
+					},
+					Body: data,
+				},
+			})
+		}
+
+		if err := r.publish(ctx, msgs); err != nil {
+			return err
+		}
+
+		last := list[len(list)-1]
+		cursor = &db.Cursor{Timestamp: last.Timestamp, Id: last.Id}
+		job.Cursor = cursor.String()
+		job.Published += int64(len(list))
+		if err := db.UpdateReplay(r.pg, *job, r.owner, leaseDuration); err != nil {
+			return err
+		}
+
+		if next == nil {
+			return nil
+		}
+
+		if job.Rate > 0 {
+			sent += len(list)
+			due := started.Add(time.Duration(float64(sent) / float64(job.Rate) * float64(time.Second)))
+			select {
+			case <-ctx.Done():
+				return ctx.Err()
+			case <-time.After(time.Until(due)):
+			}
+		}
+	}
+}
+
+// publish sends a page of messages, retrying with backoff while the broker is
+// unavailable.
+func (r *Runner) publish(ctx context.Context, msgs []queue.Message) error {
+	backoff := time.Second
+	for attempt := 1; ; attempt++ {
+		publishCtx, cancel := context.WithTimeout(ctx, publishTimeout)
+		err := r.publisher.PublishAll(publishCtx, msgs)
+		cancel()
+
+		if err == nil || ctx.Err() != nil {
+			return err
+		}
+		if !errors.Is(err, queue.ErrUnavailable) || attempt >= maxAttempts {
+			return fmt.Errorf("failed to publish after %d attempts: %w", attempt, err)
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
+
+func (r *Runner) declareExchange(name string) error {
+	ch, err := r.publisher.Channel()
+	if err != nil {
+		return err
+	}
+	defer ch.Close()
+
+	return queue.DeclareExchange(ch, name)
+}
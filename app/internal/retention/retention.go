@@ -0,0 +1,38 @@
+package retention
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/db"
+)
+
+// Enforce periodically creates the upcoming partitions of the events table
+// and removes the events that are past their retention period until the
+// context is cancelled. Several processes may run it side by side.
+func Enforce(ctx context.Context, pg *sql.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		now := time.Now()
+		if err := db.CreatePartitions(pg, now, now.AddDate(0, db.PartitionsAhead, 0)); err != nil {
+			log.Println("partitions:", err)
+		}
+
+		result, err := db.EnforceRetention(pg, now)
+		if err != nil {
+			log.Println("retention:", err)
+		} else if len(result.Dropped) > 0 || result.Deleted > 0 {
+			log.Printf("retention: dropped partitions %v and deleted %d events\n", result.Dropped, result.Deleted)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
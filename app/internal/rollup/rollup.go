@@ -0,0 +1,44 @@
+package rollup
+
+import (
+	"context"
+	"database/sql"
+	"log"
+	"time"
+
+	"github.com/allokate-ai/events/app/internal/db"
+)
+
+// How often the rollup is rebuilt from scratch, which picks up backfilled and
+// deleted events older than the lookback.
+const rebuildInterval = 24 * time.Hour
+
+// Refresh periodically recounts the hours of the rollup falling within the
+// lookback until the context is cancelled, rebuilding it entirely once a day.
+// Several processes may run it side by side.
+func Refresh(ctx context.Context, pg *sql.DB, interval, lookback time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	var lastRebuild time.Time
+
+	for {
+		var since *time.Time
+		if time.Since(lastRebuild) < rebuildInterval {
+			t := time.Now().Add(-lookback)
+			since = &t
+		}
+
+		if err := db.RefreshEventCounts(pg, since); err != nil {
+			log.Println("rollup:", err)
+		} else if since == nil {
+			lastRebuild = time.Now()
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
@@ -0,0 +1,152 @@
+package schema
+
+import (
+	"bytes"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/santhosh-tekuri/jsonschema/v5"
+
+	"github.com/allokate-ai/events/app/internal/db"
+)
+
+// Compile parses and compiles a JSON Schema document. References to external
+// documents are not allowed.
+func Compile(name string, document []byte) (*jsonschema.Schema, error) {
+	compiler := jsonschema.NewCompiler()
+	compiler.AssertFormat = true
+	compiler.LoadURL = func(s string) (io.ReadCloser, error) {
+		return nil, fmt.Errorf("external references are not supported: %s", s)
+	}
+
+	url := "schema://" + name + ".json"
+	if err := compiler.AddResource(url, bytes.NewReader(document)); err != nil {
+		return nil, err
+	}
+
+	return compiler.Compile(url)
+}
+
+// Registry validates event bodies against the latest schema registered for
+// their name. Compiled schemas are cached for a while so that validating an
+// event doesn't cost a database round trip.
+type Registry struct {
+	db  *sql.DB
+	ttl time.Duration
+
+	mu    sync.Mutex
+	cache map[string]entry
+}
+
+type entry struct {
+	// The compiled schema, or nil if the name has no schema registered.
+	schema  *jsonschema.Schema
+	expires time.Time
+}
+
+func NewRegistry(db *sql.DB, ttl time.Duration) *Registry {
+	return &Registry{
+		db:    db,
+		ttl:   ttl,
+		cache: map[string]entry{},
+	}
+}
+
+// Validate checks the body of an event with the given name against its schema
+// and returns a description of each violation. Events without a registered
+// schema are always valid.
+func (r *Registry) Validate(name string, body []byte) ([]string, error) {
+	s, err := r.get(name)
+	if err != nil || s == nil {
+		return nil, err
+	}
+
+	if len(body) == 0 {
+		body = []byte("null")
+	}
+
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	decoder.UseNumber()
+
+	var instance any
+	if err := decoder.Decode(&instance); err != nil {
+		return []string{"body must be valid JSON"}, nil
+	}
+
+	if err := s.Validate(instance); err != nil {
+		var ve *jsonschema.ValidationError
+		if !errors.As(err, &ve) {
+			return nil, err
+		}
+		return violations(ve), nil
+	}
+
+	return nil, nil
+}
+
+// Invalidate drops the cached schema for the name so that the next validation
+// picks up changes made to the registry.
+func (r *Registry) Invalidate(name string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	delete(r.cache, name)
+}
+
+func (r *Registry) get(name string) (*jsonschema.Schema, error) {
+	r.mu.Lock()
+	e, ok := r.cache[name]
+	r.mu.Unlock()
+
+	if ok && time.Now().Before(e.expires) {
+		return e.schema, nil
+	}
+
+	e = entry{expires: time.Now().Add(r.ttl)}
+
+	s, err := db.GetSchema(r.db, name, 0)
+	switch {
+	case errors.Is(err, db.ErrNoSuchSchema):
+	case err != nil:
+		return nil, err
+	default:
+		if e.schema, err = Compile(fmt.Sprintf("%s/v%d", s.Name, s.Version), s.Schema); err != nil {
+			return nil, fmt.Errorf("stored schema for \"%s\" is invalid: %w", name, err)
+		}
+	}
+
+	r.mu.Lock()
+	r.cache[name] = e
+	r.mu.Unlock()
+
+	return e.schema, nil
+}
+
+// violations flattens a validation error into one message per failed keyword,
+// prefixed with the location of the offending value within the body.
+func violations(err *jsonschema.ValidationError) []string {
+	list := []string{}
+
+	var walk func(*jsonschema.ValidationError)
+	walk = func(e *jsonschema.ValidationError) {
+		if len(e.Causes) == 0 {
+			location := "body" + strings.ReplaceAll(e.InstanceLocation, "/", ".")
+			list = append(list, fmt.Sprintf("%s: %s", location, e.Message))
+			return
+		}
+		for _, cause := range e.Causes {
+			walk(cause)
+		}
+	}
+	walk(err)
+
+	sort.Strings(list)
+	return list
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -17,6 +18,10 @@ type ArticlePublished struct {
 }
 
 func (c *Client) EmitArticlePublishedEvent(source string, article ArticlePublished) (GenericEvent, error) {
+	return c.EmitArticlePublishedEventContext(context.Background(), source, article)
+}
+
+func (c *Client) EmitArticlePublishedEventContext(ctx context.Context, source string, article ArticlePublished) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(article)
 	if err != nil {
@@ -32,5 +37,5 @@ func (c *Client) EmitArticlePublishedEvent(source string, article ArticlePublish
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -13,6 +14,10 @@ type ArticleScanned struct {
 }
 
 func (c *Client) EmitArticleScannedEvent(source string, article ArticleScanned) (GenericEvent, error) {
+	return c.EmitArticleScannedEventContext(context.Background(), source, article)
+}
+
+func (c *Client) EmitArticleScannedEventContext(ctx context.Context, source string, article ArticleScanned) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(article)
 	if err != nil {
@@ -28,5 +33,5 @@ func (c *Client) EmitArticleScannedEvent(source string, article ArticleScanned)
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
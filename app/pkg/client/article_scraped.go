@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -22,6 +23,10 @@ type ArticleScraped struct {
 }
 
 func (c *Client) EmitArticleScrapedEvent(source string, article ArticleScraped) (GenericEvent, error) {
+	return c.EmitArticleScrapedEventContext(context.Background(), source, article)
+}
+
+func (c *Client) EmitArticleScrapedEventContext(ctx context.Context, source string, article ArticleScraped) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(article)
 	if err != nil {
@@ -37,5 +42,5 @@ func (c *Client) EmitArticleScrapedEvent(source string, article ArticleScraped)
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
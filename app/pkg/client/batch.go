@@ -0,0 +1,94 @@
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/google/uuid"
+)
+
+// Number of events sent per request by PublishBatch.
+const batchChunkSize = 500
+
+// The outcome of publishing a single event as part of a batch. Id is set if
+// the event was accepted, otherwise Errors explains why it was rejected.
+type BatchResult struct {
+	Id     string   `json:"id,omitempty"`
+	Errors []string `json:"errors,omitempty"`
+}
+
+// PublishBatch publishes the events in chunks of up to 500 per request and
+// returns a result for each event, in the same order. If a request fails the
+// results of the chunks already sent are returned along with the error.
+func (c *Client) PublishBatch(list []GenericEvent) ([]BatchResult, error) {
+	return c.PublishBatchContext(context.Background(), list)
+}
+
+// PublishBatchContext is like PublishBatch but takes a context.
+func (c *Client) PublishBatchContext(ctx context.Context, list []GenericEvent) ([]BatchResult, error) {
+	results := make([]BatchResult, 0, len(list))
+
+	// Assign IDs up front so that the server accepts each event only once
+	// should a chunk be sent again.
+	prepared := make([]GenericEvent, len(list))
+	for i, event := range list {
+		if event.Timestamp.IsZero() {
+			event.Timestamp = time.Now()
+		}
+		if event.Id == "" {
+			event.Id = uuid.New().String()
+		}
+		prepared[i] = event
+	}
+	list = prepared
+
+	for start := 0; start < len(list); start += batchChunkSize {
+		end := start + batchChunkSize
+		if end > len(list) {
+			end = len(list)
+		}
+
+		chunk, err := c.publishChunk(ctx, list[start:end])
+		if err != nil {
+			return results, err
+		}
+		results = append(results, chunk...)
+	}
+
+	return results, nil
+}
+
+func (c *Client) publishChunk(ctx context.Context, list []GenericEvent) ([]BatchResult, error) {
+	rel := &url.URL{Path: "/api/events:batch"}
+	u := c.BaseURL.ResolveReference(rel)
+
+	body, err := json.Marshal(list)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, u.String(), bytes.NewBuffer(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+
+	res, err := c.do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+
+	response := struct {
+		Results []BatchResult `json:"results"`
+	}{}
+	if err := json.NewDecoder(res.Body).Decode(&response); err != nil {
+		return nil, err
+	}
+
+	return response.Results, nil
+}
@@ -2,65 +2,318 @@ package client
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
-	"errors"
+	"math/rand"
 	"net/http"
 	"net/url"
+	"strconv"
 	"time"
+
+	"github.com/google/uuid"
 )
 
+// DefaultTimeout is how long a request may take, including reading the
+// response, unless set otherwise with WithTimeout or a custom http.Client.
+const DefaultTimeout = 30 * time.Second
+
+// RetryPolicy decides how often and how soon failed requests are sent again.
+// Requests are retried on network errors and 5xx responses, waiting an
+// exponentially growing backoff with full jitter in between. Rate limited
+// requests wait as long as the server's Retry-After instead.
+type RetryPolicy struct {
+	// Attempts made in total, including the first. 1 turns retries off.
+	MaxAttempts int
+
+	// Backoff before the first retry, doubling after each one up to
+	// MaxBackoff.
+	InitialBackoff time.Duration
+	MaxBackoff     time.Duration
+
+	// The longest the server may ask to wait for a rate limit to lift.
+	// Requests told to wait longer, such as for a daily quota, fail instead.
+	MaxRetryAfter time.Duration
+}
+
+// DefaultRetryPolicy is used unless set otherwise with WithRetryPolicy.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts:    4,
+	InitialBackoff: 200 * time.Millisecond,
+	MaxBackoff:     10 * time.Second,
+	MaxRetryAfter:  time.Minute,
+}
+
+// backoff returns how long to wait before the given retry, counting from 1.
+func (p RetryPolicy) backoff(retry int) time.Duration {
+	d := p.MaxBackoff
+	if shift := retry - 1; shift < 32 {
+		if exp := p.InitialBackoff << shift; exp > 0 && exp < d {
+			d = exp
+		}
+	}
+	if d <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(d) + 1))
+}
+
 type Client struct {
 	BaseURL    *url.URL
 	httpClient *http.Client
+	apiKey     string
+	retry      RetryPolicy
+
+	// Events that can't be sent are written to the spool, if there is one,
+	// and sent later on by drain.
+	spoolDir string
+	spool    *spool
+	stop     context.CancelFunc
+	drained  chan struct{}
 }
 
-func NewClient(baseURL string, httpClient *http.Client) (*Client, error) {
+// Option configures a Client.
+type Option func(*Client)
+
+// WithAPIKey makes the client authenticate its requests with an API key.
+func WithAPIKey(key string) Option {
+	return func(c *Client) {
+		c.apiKey = key
+	}
+}
+
+// WithRetryPolicy replaces the DefaultRetryPolicy.
+func WithRetryPolicy(policy RetryPolicy) Option {
+	return func(c *Client) {
+		c.retry = policy
+	}
+}
+
+// WithTimeout sets how long each attempt at a request may take, in place of
+// the DefaultTimeout or the timeout of the http.Client passed to NewClient.
+func WithTimeout(timeout time.Duration) Option {
+	return func(c *Client) {
+		httpClient := *c.httpClient
+		httpClient.Timeout = timeout
+		c.httpClient = &httpClient
+	}
+}
+
+func NewClient(baseURL string, httpClient *http.Client, opts ...Option) (*Client, error) {
 	u, err := url.Parse(baseURL)
 	if err != nil {
 		return &Client{}, err
 	}
 
 	if httpClient == nil {
-		httpClient = &http.Client{}
+		httpClient = &http.Client{Timeout: DefaultTimeout}
 	}
 
-	return &Client{
+	c := &Client{
 		BaseURL:    u,
 		httpClient: httpClient,
-	}, nil
+		retry:      DefaultRetryPolicy,
+	}
+	for _, opt := range opts {
+		opt(c)
+	}
+
+	if c.spoolDir != "" {
+		s, err := openSpool(c.spoolDir)
+		if err != nil {
+			return &Client{}, err
+		}
+		c.spool = s
+
+		ctx, stop := context.WithCancel(context.Background())
+		c.stop = stop
+		c.drained = make(chan struct{})
+		go c.drain(ctx)
+	}
+
+	return c, nil
 }
 
-func (c *Client) Publish(event GenericEvent) (GenericEvent, error) {
-	rel := &url.URL{Path: "/api/events"}
+// do sends the request along with the client's credentials, retrying it as
+// the retry policy allows. Every request the client makes is safe to send
+// more than once since events carry their ID as the idempotency key. Error
+// responses are returned as an *APIError.
+func (c *Client) do(req *http.Request) (*http.Response, error) {
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+
+	for attempt := 1; ; attempt++ {
+		res, err := c.httpClient.Do(req)
+		if err == nil && res.StatusCode < 400 {
+			return res, nil
+		}
+
+		// Work out whether and how long to wait before trying again.
+		var wait time.Duration
+		retry := attempt < c.retry.MaxAttempts && (req.Body == nil || req.GetBody != nil)
+		switch {
+		case err != nil:
+			// Give up on requests that were cancelled or timed out on
+			// purpose.
+			if req.Context().Err() != nil {
+				return nil, req.Context().Err()
+			}
+			wait = c.retry.backoff(attempt)
+		case res.StatusCode == http.StatusTooManyRequests:
+			if after, ok := retryAfter(res); ok {
+				wait = after
+				retry = retry && after <= c.retry.MaxRetryAfter
+			} else {
+				wait = c.retry.backoff(attempt)
+			}
+		case res.StatusCode == http.StatusConflict && res.Header.Get("Retry-After") != "":
+			// The same event is still being published by an earlier
+			// attempt whose outcome isn't known yet.
+			after, _ := retryAfter(res)
+			wait = after
+			retry = retry && after <= c.retry.MaxRetryAfter
+		case res.StatusCode >= 500:
+			wait = c.retry.backoff(attempt)
+		default:
+			retry = false
+		}
+
+		if !retry {
+			if err != nil {
+				return nil, err
+			}
+			defer res.Body.Close()
+			return nil, newAPIError(res)
+		}
+		if res != nil {
+			res.Body.Close()
+		}
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req.Body = body
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// getJSON fetches the path with the raw query, decoding the JSON response into
+// out.
+func (c *Client) getJSON(ctx context.Context, path, rawQuery string, out any) error {
+	rel := &url.URL{Path: path, RawQuery: rawQuery}
 	u := c.BaseURL.ResolveReference(rel)
 
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u.String(), nil)
+	if err != nil {
+		return err
+	}
+
+	res, err := c.do(req)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+
+	return json.NewDecoder(res.Body).Decode(out)
+}
+
+// retryAfter reads how long the server asked to wait from the Retry-After
+// header, given either in seconds or as a date.
+func retryAfter(res *http.Response) (time.Duration, bool) {
+	val := res.Header.Get("Retry-After")
+	if val == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(val); err == nil && seconds >= 0 {
+		return time.Duration(seconds) * time.Second, true
+	}
+	if t, err := http.ParseTime(val); err == nil {
+		if wait := time.Until(t); wait > 0 {
+			return wait, true
+		}
+		return 0, true
+	}
+	return 0, false
+}
+
+func (c *Client) Publish(event GenericEvent) (GenericEvent, error) {
+	return c.PublishContext(context.Background(), event)
+}
+
+// PublishContext is Publish with a context that bounds every attempt at
+// the request, including the waits between retries.
+func (c *Client) PublishContext(ctx context.Context, event GenericEvent) (GenericEvent, error) {
 	if event.Timestamp.IsZero() {
 		event.Timestamp = time.Now()
 	}
 
+	// The ID doubles as the idempotency key so that the server only accepts
+	// the event once, however many times it is sent.
+	if event.Id == "" {
+		event.Id = uuid.New().String()
+	}
+
+	if c.spool == nil {
+		return c.send(ctx, event)
+	}
+
+	// Queue up behind the events already waiting so that they are all sent
+	// in order.
+	if c.spool.stats().Events > 0 {
+		if err := c.spool.append(event); err != nil {
+			return GenericEvent{}, err
+		}
+		return event, nil
+	}
+
+	e, err := c.send(ctx, event)
+	if err != nil && ctx.Err() == nil && retryable(err) {
+		if err := c.spool.append(event); err != nil {
+			return GenericEvent{}, err
+		}
+		return event, nil
+	}
+	return e, err
+}
+
+// send makes a single request to publish the event, retried as the retry
+// policy allows.
+func (c *Client) send(ctx context.Context, event GenericEvent) (GenericEvent, error) {
+	rel := &url.URL{Path: "/api/events"}
+	u := c.BaseURL.ResolveReference(rel)
+
 	body, err := json.Marshal(&event)
 	if err != nil {
 		return GenericEvent{}, err
 	}
 
-	req, err := http.NewRequest(http.MethodPut, u.String(), bytes.NewBuffer(body))
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, u.String(), bytes.NewBuffer(body))
 	if err != nil {
 		return GenericEvent{}, err
 	}
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Idempotency-Key", event.Id)
 
-	res, err := c.httpClient.Do(req)
+	res, err := c.do(req)
 	if err != nil {
 		return GenericEvent{}, err
 	}
 	defer res.Body.Close()
 
-	if res.StatusCode != http.StatusOK {
-		return GenericEvent{}, errors.New(res.Status)
-	}
-
 	e := GenericEvent{}
-	json.NewDecoder(res.Body).Decode(&e)
+	if err := json.NewDecoder(res.Body).Decode(&e); err != nil {
+		return GenericEvent{}, err
+	}
 
 	return e, nil
 }
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -23,6 +24,10 @@ type CongressionalTrade struct {
 }
 
 func (c *Client) EmitCongressionalTradeEvent(source string, trade CongressionalTrade) (GenericEvent, error) {
+	return c.EmitCongressionalTradeEventContext(context.Background(), source, trade)
+}
+
+func (c *Client) EmitCongressionalTradeEventContext(ctx context.Context, source string, trade CongressionalTrade) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(trade)
 	if err != nil {
@@ -38,5 +43,5 @@ func (c *Client) EmitCongressionalTradeEvent(source string, trade CongressionalT
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
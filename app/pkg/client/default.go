@@ -12,7 +12,15 @@ func Default() *Client {
 
 	if c == nil {
 		// Declare a client that will be used to publish new articles.
-		cli, err := NewClient(environment.GetValueOrDefault("EVENT_SERVICE_API", "http://localhost:8094"), nil)
+		opts := []Option{}
+		if key := environment.GetValueOrDefault("EVENT_SERVICE_API_KEY", ""); key != "" {
+			opts = append(opts, WithAPIKey(key))
+		}
+		if dir := environment.GetValueOrDefault("EVENT_SERVICE_SPOOL", ""); dir != "" {
+			opts = append(opts, WithSpool(dir))
+		}
+
+		cli, err := NewClient(environment.GetValueOrDefault("EVENT_SERVICE_API", "http://localhost:8094"), nil, opts...)
 		if err != nil {
 			panic(err)
 		}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -18,6 +19,10 @@ type Dividend struct {
 }
 
 func (c *Client) EmitDividendEvent(source string, body Dividend) (GenericEvent, error) {
+	return c.EmitDividendEventContext(context.Background(), source, body)
+}
+
+func (c *Client) EmitDividendEventContext(ctx context.Context, source string, body Dividend) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(body)
 	if err != nil {
@@ -33,5 +38,5 @@ func (c *Client) EmitDividendEvent(source string, body Dividend) (GenericEvent,
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -13,6 +14,10 @@ type Earnings struct {
 }
 
 func (c *Client) EmitEarningsEvent(source string, body Earnings) (GenericEvent, error) {
+	return c.EmitEarningsEventContext(context.Background(), source, body)
+}
+
+func (c *Client) EmitEarningsEventContext(ctx context.Context, source string, body Earnings) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(body)
 	if err != nil {
@@ -28,5 +33,5 @@ func (c *Client) EmitEarningsEvent(source string, body Earnings) (GenericEvent,
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
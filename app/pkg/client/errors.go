@@ -0,0 +1,68 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// APIError is returned when the server responds with an error status. Errors
+// holds the reasons the server gave, such as each way an event failed
+// validation.
+type APIError struct {
+	StatusCode int
+	Status     string
+	Errors     []string
+
+	// How long the server asked to wait before trying again, if it did.
+	RetryAfter time.Duration
+}
+
+func (e *APIError) Error() string {
+	if len(e.Errors) == 0 {
+		return e.Status
+	}
+	return fmt.Sprintf("%s: %s", e.Status, strings.Join(e.Errors, "; "))
+}
+
+// Temporary reports whether the request may succeed if sent again later, as
+// opposed to being rejected for what it holds.
+func (e *APIError) Temporary() bool {
+	return e.StatusCode == http.StatusTooManyRequests || e.StatusCode >= 500 || e.RetryAfter > 0
+}
+
+// The largest error response read.
+const maxErrorBody = 1 << 20
+
+// newAPIError reads the errors out of an error response. The server sends
+// either a list of errors or a single one.
+func newAPIError(res *http.Response) *APIError {
+	e := &APIError{StatusCode: res.StatusCode, Status: res.Status}
+	if wait, ok := retryAfter(res); ok {
+		e.RetryAfter = wait
+	}
+
+	data, err := io.ReadAll(io.LimitReader(res.Body, maxErrorBody))
+	if err != nil {
+		return e
+	}
+
+	body := struct {
+		Errors json.RawMessage `json:"errors"`
+	}{}
+	if err := json.Unmarshal(data, &body); err != nil || len(body.Errors) == 0 {
+		return e
+	}
+
+	var list []string
+	var single string
+	if err := json.Unmarshal(body.Errors, &list); err == nil {
+		e.Errors = list
+	} else if err := json.Unmarshal(body.Errors, &single); err == nil {
+		e.Errors = []string{single}
+	}
+	return e
+}
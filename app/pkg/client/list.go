@@ -0,0 +1,229 @@
+package client
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// A single page of events as returned by GET /api/events.
+type EventPage struct {
+	Events     []GenericEvent `json:"events"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// Filters and page size used when listing events.
+type ListOptions struct {
+	From   *time.Time
+	To     *time.Time
+	Name   string
+	Source string
+	Limit  int
+
+	// Filters on fields of the event body, built with Field.
+	Body []BodyFilter
+}
+
+func (o ListOptions) values() url.Values {
+	q := url.Values{}
+	if o.From != nil {
+		q.Set("from", o.From.Format(time.RFC3339))
+	}
+	if o.To != nil {
+		q.Set("to", o.To.Format(time.RFC3339))
+	}
+	if o.Name != "" {
+		q.Set("name", o.Name)
+	}
+	if o.Source != "" {
+		q.Set("source", o.Source)
+	}
+	if o.Limit > 0 {
+		q.Set("limit", strconv.Itoa(o.Limit))
+	}
+	return q
+}
+
+// ListEvents fetches the page of events that follows the given cursor. Pass an
+// empty cursor to fetch the first (most recent) page.
+func (c *Client) ListEvents(opts ListOptions, cursor string) (EventPage, error) {
+	return c.ListEventsContext(context.Background(), opts, cursor)
+}
+
+// ListEventsContext is like ListEvents but takes a context.
+func (c *Client) ListEventsContext(ctx context.Context, opts ListOptions, cursor string) (EventPage, error) {
+	q := opts.values()
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+
+	page := EventPage{}
+	if err := c.getJSON(ctx, "/api/events", appendBodyFilters(q.Encode(), opts.Body), &page); err != nil {
+		return EventPage{}, err
+	}
+	return page, nil
+}
+
+// EventIterator walks through every event matching a set of list options,
+// fetching pages from the server as needed.
+//
+//	it := c.Events(client.ListOptions{Name: "dividend"})
+//	for it.Next() {
+//		fmt.Println(it.Event())
+//	}
+//	if err := it.Err(); err != nil {
+//		...
+//	}
+type EventIterator struct {
+	client *Client
+	ctx    context.Context
+	opts   ListOptions
+	page   []GenericEvent
+	index  int
+	cursor string
+	done   bool
+	err    error
+}
+
+// Events returns an iterator over all events matching the list options.
+func (c *Client) Events(opts ListOptions) *EventIterator {
+	return c.EventsContext(context.Background(), opts)
+}
+
+// EventsContext returns an iterator over all events matching the list options
+// that fetches pages with the given context.
+func (c *Client) EventsContext(ctx context.Context, opts ListOptions) *EventIterator {
+	return &EventIterator{client: c, ctx: ctx, opts: opts, index: -1}
+}
+
+// Next advances to the next event, returning false when there are no more
+// events or an error occurred.
+func (it *EventIterator) Next() bool {
+	if it.err != nil {
+		return false
+	}
+
+	it.index++
+	for it.index >= len(it.page) {
+		if it.done {
+			return false
+		}
+
+		page, err := it.client.ListEventsContext(it.ctx, it.opts, it.cursor)
+		if err != nil {
+			it.err = err
+			return false
+		}
+
+		it.page = page.Events
+		it.index = 0
+		it.cursor = page.NextCursor
+		it.done = page.NextCursor == ""
+	}
+
+	return true
+}
+
+// Event returns the current event.
+func (it *EventIterator) Event() GenericEvent {
+	return it.page[it.index]
+}
+
+// Err returns the error, if any, that stopped the iteration.
+func (it *EventIterator) Err() error {
+	return it.err
+}
+
+// An event matching a search, along with how well it matched and a snippet of
+// its text with the matching terms wrapped in <mark> tags.
+type SearchResult struct {
+	Event    GenericEvent `json:"event"`
+	Rank     float64      `json:"rank"`
+	Headline string       `json:"headline"`
+}
+
+// A single page of results as returned by GET /api/events/search.
+type SearchPage struct {
+	Results    []SearchResult `json:"results"`
+	NextCursor string         `json:"next_cursor,omitempty"`
+}
+
+// Search fetches the page of events matching a web search style query, such
+// as `"interest rates" -fed`, that follows the given cursor. Results are
+// ranked best match first. The name, source, from, to and limit options
+// narrow down the results as they do for ListEvents.
+func (c *Client) Search(query string, opts ListOptions, cursor string) (SearchPage, error) {
+	return c.SearchContext(context.Background(), query, opts, cursor)
+}
+
+// SearchContext is like Search but takes a context.
+func (c *Client) SearchContext(ctx context.Context, query string, opts ListOptions, cursor string) (SearchPage, error) {
+	q := opts.values()
+	q.Set("q", query)
+	if cursor != "" {
+		q.Set("cursor", cursor)
+	}
+
+	page := SearchPage{}
+	if err := c.getJSON(ctx, "/api/events/search", appendBodyFilters(q.Encode(), opts.Body), &page); err != nil {
+		return SearchPage{}, err
+	}
+	return page, nil
+}
+
+// The number of events in a bucket of time, as returned by
+// GET /api/events/stats. Name and source are only set when the counts are
+// grouped by them.
+type StatsBucket struct {
+	Timestamp time.Time `json:"timestamp"`
+	Name      string    `json:"name,omitempty"`
+	Source    string    `json:"source,omitempty"`
+	Count     int64     `json:"count"`
+}
+
+// Event counts over time as returned by GET /api/events/stats.
+type Stats struct {
+	Interval string        `json:"interval"`
+	Buckets  []StatsBucket `json:"buckets"`
+}
+
+// Options for counting events over time.
+type StatsOptions struct {
+	ListOptions
+
+	// One of minute, hour or day. Defaults to hour.
+	Interval string
+
+	// Any of name and source.
+	GroupBy []string
+
+	// Serve the counts from the hourly rollups, which is faster over long
+	// ranges but counts whole hours and may lag behind.
+	Rollup bool
+}
+
+// Stats counts the events matching the options in buckets of time.
+func (c *Client) Stats(opts StatsOptions) (Stats, error) {
+	return c.StatsContext(context.Background(), opts)
+}
+
+// StatsContext is like Stats but takes a context.
+func (c *Client) StatsContext(ctx context.Context, opts StatsOptions) (Stats, error) {
+	q := opts.values()
+	if opts.Interval != "" {
+		q.Set("interval", opts.Interval)
+	}
+	for _, group := range opts.GroupBy {
+		q.Add("group_by", group)
+	}
+	if opts.Rollup {
+		q.Set("rollup", "true")
+	}
+
+	stats := Stats{}
+	if err := c.getJSON(ctx, "/api/events/stats", appendBodyFilters(q.Encode(), opts.Body), &stats); err != nil {
+		return Stats{}, err
+	}
+	return stats, nil
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -15,6 +16,10 @@ type Login struct {
 }
 
 func (c *Client) EmitLoginEvent(source string, payload Login) (GenericEvent, error) {
+	return c.EmitLoginEventContext(context.Background(), source, payload)
+}
+
+func (c *Client) EmitLoginEventContext(ctx context.Context, source string, payload Login) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -30,5 +35,5 @@ func (c *Client) EmitLoginEvent(source string, payload Login) (GenericEvent, err
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
@@ -0,0 +1,85 @@
+package client
+
+import (
+	"encoding/json"
+	"net/url"
+	"strings"
+)
+
+// BodyFilter narrows down listed events by a field of their body. Build one
+// with Field.
+type BodyFilter string
+
+// FieldRef names a field of the event body by its dot separated path.
+type FieldRef struct {
+	path string
+}
+
+// Field starts a filter on the body field at the given dot separated path.
+//
+//	opts := client.ListOptions{
+//		Name: "dividend",
+//		Body: []client.BodyFilter{
+//			client.Field("ticker").Eq("AAPL"),
+//			client.Field("dividendRate").Gt(0.5),
+//		},
+//	}
+func Field(path string) FieldRef {
+	return FieldRef{path: path}
+}
+
+func (f FieldRef) filter(op string, value any) BodyFilter {
+	return BodyFilter("body." + f.path + op + formatValue(value))
+}
+
+// Eq matches events where the field equals the value.
+func (f FieldRef) Eq(value any) BodyFilter { return f.filter("=", value) }
+
+// Ne matches events where the field is missing or doesn't equal the value.
+func (f FieldRef) Ne(value any) BodyFilter { return f.filter("!=", value) }
+
+// Gt matches events where the field is greater than the value, which must be
+// a number or a string.
+func (f FieldRef) Gt(value any) BodyFilter { return f.filter(">", value) }
+
+// Gte matches events where the field is at least the value.
+func (f FieldRef) Gte(value any) BodyFilter { return f.filter(">=", value) }
+
+// Lt matches events where the field is less than the value.
+func (f FieldRef) Lt(value any) BodyFilter { return f.filter("<", value) }
+
+// Lte matches events where the field is at most the value.
+func (f FieldRef) Lte(value any) BodyFilter { return f.filter("<=", value) }
+
+// Contains matches events where the field is an array holding the value.
+func (f FieldRef) Contains(value any) BodyFilter { return f.filter("[]=", value) }
+
+// formatValue renders a value the way the server reads it back. Strings are
+// left bare unless they would otherwise be read as a number, boolean or null.
+func formatValue(value any) string {
+	if s, ok := value.(string); ok {
+		var probe any
+		if !strings.HasPrefix(s, `"`) && json.Unmarshal([]byte(s), &probe) != nil {
+			return s
+		}
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return ""
+	}
+	return string(data)
+}
+
+// appendBodyFilters adds the filters to an encoded query string. Each filter
+// is escaped whole as the server splits it on its operator.
+func appendBodyFilters(rawQuery string, filters []BodyFilter) string {
+	parts := []string{}
+	if rawQuery != "" {
+		parts = append(parts, rawQuery)
+	}
+	for _, filter := range filters {
+		parts = append(parts, url.QueryEscape(string(filter)))
+	}
+	return strings.Join(parts, "&")
+}
@@ -0,0 +1,108 @@
+package client
+
+import (
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Bodies maps the name of each event published by this package to the type of
+// its body.
+var Bodies = map[string]any{
+	"article.published":   ArticlePublished{},
+	"article.scanned":     ArticleScanned{},
+	"article.scraped":     ArticleScraped{},
+	"congressional_trade": CongressionalTrade{},
+	"dividend":            Dividend{},
+	"earnings":            Earnings{},
+	"login":               Login{},
+	"tweet":               Tweet{},
+	"user.invite":         Invite{},
+	"user.solicitation":   Solicitation{},
+}
+
+// Schemas returns a JSON Schema for the body of each event in Bodies, suitable
+// for seeding the schema registry.
+func Schemas() map[string]map[string]any {
+	schemas := map[string]map[string]any{}
+	for name, body := range Bodies {
+		schemas[name] = JSONSchema(body)
+	}
+	return schemas
+}
+
+// JSONSchema derives a JSON Schema from the type of v following the rules of
+// encoding/json. Fields without omitempty are required, pointer, slice and
+// map fields may be null, and unknown properties are allowed so that
+// producers can add fields without breaking validation.
+func JSONSchema(v any) map[string]any {
+	schema := typeSchema(reflect.TypeOf(v))
+	schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+	return schema
+}
+
+var timeType = reflect.TypeOf(time.Time{})
+
+func typeSchema(t reflect.Type) map[string]any {
+	if t == timeType {
+		return map[string]any{"type": "string", "format": "date-time"}
+	}
+
+	switch t.Kind() {
+	case reflect.Pointer:
+		return nullable(typeSchema(t.Elem()))
+	case reflect.String:
+		return map[string]any{"type": "string"}
+	case reflect.Bool:
+		return map[string]any{"type": "boolean"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return map[string]any{"type": "integer"}
+	case reflect.Float32, reflect.Float64:
+		return map[string]any{"type": "number"}
+	case reflect.Slice, reflect.Array:
+		return nullable(map[string]any{"type": "array", "items": typeSchema(t.Elem())})
+	case reflect.Map:
+		return nullable(map[string]any{"type": "object", "additionalProperties": typeSchema(t.Elem())})
+	case reflect.Struct:
+		properties := map[string]any{}
+		required := []string{}
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			if !field.IsExported() {
+				continue
+			}
+
+			name := field.Name
+			omitempty := false
+			if tag, ok := field.Tag.Lookup("json"); ok {
+				parts := strings.Split(tag, ",")
+				if parts[0] == "-" {
+					continue
+				}
+				if parts[0] != "" {
+					name = parts[0]
+				}
+				for _, option := range parts[1:] {
+					omitempty = omitempty || option == "omitempty"
+				}
+			}
+
+			properties[name] = typeSchema(field.Type)
+			if !omitempty {
+				required = append(required, name)
+			}
+		}
+		return map[string]any{"type": "object", "properties": properties, "required": required}
+	default:
+		return map[string]any{}
+	}
+}
+
+// nullable extends a schema to also accept null.
+func nullable(schema map[string]any) map[string]any {
+	if t, ok := schema["type"].(string); ok {
+		schema["type"] = []string{t, "null"}
+	}
+	return schema
+}
@@ -0,0 +1,555 @@
+package client
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// Segments are rolled over once they grow past this size.
+	maxSegmentSize = 16 << 20
+
+	// Records larger than this are taken to be corrupt.
+	maxRecordSize = 64 << 20
+
+	// Every record starts with the length and CRC-32 of its payload.
+	recordHeaderSize = 8
+
+	segmentSuffix = ".seg"
+	cursorFile    = "cursor"
+)
+
+// SpoolStats describes how many events are waiting in the spool to be sent.
+type SpoolStats struct {
+	// Events and bytes that haven't been sent yet.
+	Events int
+	Bytes  int64
+
+	// Segment files the spool is made of.
+	Segments int
+
+	// Events the server rejected while draining the spool, which were
+	// dropped, and the last error draining ran into.
+	Dropped   int
+	LastError error
+}
+
+// spool is a write-ahead log of events on disk, made of numbered segment files
+// that are only ever appended to. Each record is an event encoded as JSON,
+// preceded by its length and checksum so that a record torn by a crash can be
+// told apart. The position of the next event to send is kept in the cursor
+// file, and segments are deleted once every event in them has been sent.
+type spool struct {
+	dir string
+
+	mu       sync.Mutex
+	segments []uint64
+	w        *os.File
+	wsize    int64
+	cursor   spoolCursor
+	events   int
+	bytes    int64
+	dropped  int
+	lastErr  error
+	appended chan struct{}
+}
+
+type spoolCursor struct {
+	Segment uint64 `json:"segment"`
+	Offset  int64  `json:"offset"`
+}
+
+func segmentName(n uint64) string {
+	return fmt.Sprintf("%020d%s", n, segmentSuffix)
+}
+
+// openSpool opens the spool in the directory, creating it if need be, and
+// counts the events still waiting in it.
+func openSpool(dir string) (*spool, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+
+	s := &spool{dir: dir, appended: make(chan struct{}, 1)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, segmentSuffix) {
+			continue
+		}
+		n, err := strconv.ParseUint(strings.TrimSuffix(name, segmentSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		s.segments = append(s.segments, n)
+	}
+	sort.Slice(s.segments, func(i, j int) bool { return s.segments[i] < s.segments[j] })
+
+	data, err := os.ReadFile(filepath.Join(dir, cursorFile))
+	if err != nil && !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+	if err == nil {
+		if err := json.Unmarshal(data, &s.cursor); err != nil {
+			return nil, fmt.Errorf("malformed spool cursor: %w", err)
+		}
+	}
+
+	// Drop the segments that were sent in full but not yet deleted.
+	for len(s.segments) > 0 && s.segments[0] < s.cursor.Segment {
+		if err := os.Remove(filepath.Join(dir, segmentName(s.segments[0]))); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, err
+		}
+		s.segments = s.segments[1:]
+	}
+	if len(s.segments) == 0 || s.segments[0] > s.cursor.Segment {
+		s.cursor.Offset = 0
+	}
+	if len(s.segments) > 0 {
+		s.cursor.Segment = s.segments[0]
+	}
+
+	// Count what is left, cutting off the torn record a crash may have left at
+	// the end of the last segment.
+	for i, n := range s.segments {
+		offset := int64(0)
+		if n == s.cursor.Segment {
+			offset = s.cursor.Offset
+		}
+		events, end, size, err := s.scan(n, offset)
+		if err != nil {
+			return nil, err
+		}
+		s.events += events
+		s.bytes += end - offset
+
+		if i == len(s.segments)-1 {
+			if end < size {
+				if err := os.Truncate(filepath.Join(dir, segmentName(n)), end); err != nil {
+					return nil, err
+				}
+			}
+			s.wsize = end
+		}
+	}
+
+	return s, nil
+}
+
+// scan counts the intact records of a segment from the offset on, returning
+// where they end and the size of the file.
+func (s *spool) scan(n uint64, offset int64) (int, int64, int64, error) {
+	f, err := os.Open(filepath.Join(s.dir, segmentName(n)))
+	if err != nil {
+		return 0, 0, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return 0, 0, 0, err
+	}
+
+	events := 0
+	for {
+		_, size, err := readRecord(f, offset)
+		if err != nil {
+			return events, offset, info.Size(), nil
+		}
+		events++
+		offset += size
+	}
+}
+
+// readRecord reads the record at the offset, returning its payload and its
+// size on disk. io.EOF is returned at the end of the file and
+// io.ErrUnexpectedEOF for a torn or corrupt record.
+func readRecord(f *os.File, offset int64) ([]byte, int64, error) {
+	header := make([]byte, recordHeaderSize)
+	if _, err := f.ReadAt(header, offset); err != nil {
+		if err == io.EOF && isEnd(f, offset) {
+			return nil, 0, io.EOF
+		}
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	length := binary.BigEndian.Uint32(header[0:4])
+	if length > maxRecordSize {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	payload := make([]byte, length)
+	if _, err := f.ReadAt(payload, offset+recordHeaderSize); err != nil {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+	if crc32.ChecksumIEEE(payload) != binary.BigEndian.Uint32(header[4:8]) {
+		return nil, 0, io.ErrUnexpectedEOF
+	}
+
+	return payload, recordHeaderSize + int64(length), nil
+}
+
+func isEnd(f *os.File, offset int64) bool {
+	info, err := f.Stat()
+	return err == nil && info.Size() <= offset
+}
+
+// append adds the event to the end of the spool, syncing it to disk before
+// returning.
+func (s *spool) append(event GenericEvent) error {
+	payload, err := json.Marshal(&event)
+	if err != nil {
+		return err
+	}
+	record := make([]byte, recordHeaderSize+len(payload))
+	binary.BigEndian.PutUint32(record[0:4], uint32(len(payload)))
+	binary.BigEndian.PutUint32(record[4:8], crc32.ChecksumIEEE(payload))
+	copy(record[recordHeaderSize:], payload)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	full := s.wsize > 0 && s.wsize+int64(len(record)) > maxSegmentSize
+	if s.w == nil || full {
+		if err := s.roll(full); err != nil {
+			return err
+		}
+	}
+
+	if _, err := s.w.Write(record); err != nil {
+		// Cut off whatever part of the record was written.
+		s.w.Truncate(s.wsize)
+		return err
+	}
+	if err := s.w.Sync(); err != nil {
+		return err
+	}
+	s.wsize += int64(len(record))
+	s.events++
+	s.bytes += int64(len(record))
+
+	select {
+	case s.appended <- struct{}{}:
+	default:
+	}
+	return nil
+}
+
+// roll opens the segment that appends go to: the last one, or a new one if
+// that is full.
+func (s *spool) roll(full bool) error {
+	if s.w != nil {
+		if err := s.w.Close(); err != nil {
+			return err
+		}
+		s.w = nil
+	}
+
+	if len(s.segments) == 0 || full {
+		n := s.cursor.Segment
+		if len(s.segments) > 0 {
+			n = s.segments[len(s.segments)-1] + 1
+		}
+		s.segments = append(s.segments, n)
+		s.wsize = 0
+	}
+
+	f, err := os.OpenFile(filepath.Join(s.dir, segmentName(s.segments[len(s.segments)-1])), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+	s.w = f
+	return nil
+}
+
+// peek returns the next event to send without taking it out of the spool,
+// along with its size. It returns false if the spool is empty.
+func (s *spool) peek() (GenericEvent, int64, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for len(s.segments) > 0 {
+		f, err := os.Open(filepath.Join(s.dir, segmentName(s.cursor.Segment)))
+		if err != nil {
+			return GenericEvent{}, 0, false, err
+		}
+		payload, size, err := readRecord(f, s.cursor.Offset)
+		f.Close()
+
+		if err == nil {
+			event := GenericEvent{}
+			if err := json.Unmarshal(payload, &event); err != nil {
+				return GenericEvent{}, 0, false, err
+			}
+			return event, size, true, nil
+		}
+
+		// Move on from a segment that has been read to the end. Anything
+		// corrupt left in it can't be sent.
+		if len(s.segments) == 1 {
+			break
+		}
+		if err := s.next(); err != nil {
+			return GenericEvent{}, 0, false, err
+		}
+	}
+	return GenericEvent{}, 0, false, nil
+}
+
+// advance takes the event that peek returned out of the spool.
+func (s *spool) advance(size int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cursor.Offset += size
+	if s.events > 0 {
+		s.events--
+		s.bytes -= size
+	}
+
+	if len(s.segments) > 1 && !s.hasMore() {
+		return s.next()
+	}
+	return s.saveCursor()
+}
+
+// hasMore reports whether the segment under the cursor holds more records.
+func (s *spool) hasMore() bool {
+	info, err := os.Stat(filepath.Join(s.dir, segmentName(s.cursor.Segment)))
+	return err != nil || info.Size() > s.cursor.Offset
+}
+
+// next moves the cursor to the following segment and deletes the one it was
+// in.
+func (s *spool) next() error {
+	done := s.segments[0]
+	s.segments = s.segments[1:]
+	s.cursor = spoolCursor{Segment: s.segments[0]}
+	if err := s.saveCursor(); err != nil {
+		return err
+	}
+	return os.Remove(filepath.Join(s.dir, segmentName(done)))
+}
+
+// saveCursor writes the cursor to a temporary file and renames it into place
+// so that it is never left half written.
+func (s *spool) saveCursor() error {
+	data, err := json.Marshal(s.cursor)
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(s.dir, cursorFile)
+	f, err := os.OpenFile(path+".tmp", os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o600)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// fail records an error met while draining the spool. Dropped events are
+// counted.
+func (s *spool) fail(err error, dropped bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.lastErr = err
+	if dropped {
+		s.dropped++
+	}
+}
+
+func (s *spool) stats() SpoolStats {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return SpoolStats{
+		Events:    s.events,
+		Bytes:     s.bytes,
+		Segments:  len(s.segments),
+		Dropped:   s.dropped,
+		LastError: s.lastErr,
+	}
+}
+
+func (s *spool) close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.w == nil {
+		return nil
+	}
+	err := s.w.Close()
+	s.w = nil
+	return err
+}
+
+const (
+	// Delays between attempts at sending the event at the front of the spool
+	// grow from the first value to the second.
+	minDrainBackoff = time.Second
+	maxDrainBackoff = time.Minute
+
+	// How often the spool is checked for events while it seems empty.
+	drainInterval = 10 * time.Second
+)
+
+// WithSpool makes the client write events to an on-disk spool in the
+// directory when the server can't be reached or fails, rather than returning
+// an error. Spooled events are sent in the background, in order and with
+// their original IDs and timestamps, once the server is back. Call Close to
+// stop sending them.
+func WithSpool(dir string) Option {
+	return func(c *Client) {
+		c.spoolDir = dir
+	}
+}
+
+// SpoolStats reports how many events are waiting in the spool. It returns the
+// zero value if the client has no spool.
+func (c *Client) SpoolStats() SpoolStats {
+	if c.spool == nil {
+		return SpoolStats{}
+	}
+	return c.spool.stats()
+}
+
+// Close stops sending spooled events and closes the spool. Events still in it
+// are sent by the next client opening the same directory.
+func (c *Client) Close() error {
+	if c.spool == nil {
+		return nil
+	}
+	c.stop()
+	<-c.drained
+	return c.spool.close()
+}
+
+// retryable reports whether sending an event failed for reasons that may pass,
+// such as the server being unreachable, rather than the event being rejected.
+func retryable(err error) bool {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Temporary()
+	}
+	return true
+}
+
+// rejected reports whether the server turned the event down for what it holds,
+// so that sending it again can't succeed. Other failures, such as a revoked
+// API key, may be put right and leave the event in the spool.
+func rejected(err error) bool {
+	var apiErr *APIError
+	if !errors.As(err, &apiErr) {
+		return false
+	}
+	switch apiErr.StatusCode {
+	case http.StatusBadRequest, http.StatusRequestEntityTooLarge, http.StatusUnprocessableEntity:
+		return true
+	case http.StatusConflict:
+		// Unless it is being published by an earlier attempt.
+		return apiErr.RetryAfter == 0
+	}
+	return false
+}
+
+// drain sends the events in the spool one at a time, in order, until the
+// context is cancelled. Events the server rejects as invalid are dropped so
+// that they don't hold up the rest; on any other failure the event is kept and
+// sent again after a backoff.
+func (c *Client) drain(ctx context.Context) {
+	defer close(c.drained)
+
+	backoff := time.Duration(0)
+	for {
+		event, size, ok, err := c.spool.peek()
+		if err != nil {
+			c.spool.fail(err, false)
+			if !sleep(ctx, nil, drainInterval) {
+				return
+			}
+			continue
+		}
+
+		// Wait for events to be spooled.
+		if !ok {
+			if !sleep(ctx, c.spool.appended, drainInterval) {
+				return
+			}
+			continue
+		}
+
+		if _, err := c.send(ctx, event); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+
+			// Keep the event until the server is back.
+			if !rejected(err) {
+				c.spool.fail(err, false)
+				if backoff *= 2; backoff == 0 {
+					backoff = minDrainBackoff
+				} else if backoff > maxDrainBackoff {
+					backoff = maxDrainBackoff
+				}
+				if !sleep(ctx, nil, backoff) {
+					return
+				}
+				continue
+			}
+
+			c.spool.fail(fmt.Errorf("dropped event %s: %w", event.Id, err), true)
+		}
+		backoff = 0
+
+		if err := c.spool.advance(size); err != nil {
+			c.spool.fail(err, false)
+			if !sleep(ctx, nil, drainInterval) {
+				return
+			}
+		}
+	}
+}
+
+// sleep waits for the duration to pass or for a signal on the channel. It
+// returns false if the context is cancelled first.
+func sleep(ctx context.Context, wake <-chan struct{}, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+
+	select {
+	case <-ctx.Done():
+		return false
+	case <-wake:
+		return true
+	case <-timer.C:
+		return true
+	}
+}
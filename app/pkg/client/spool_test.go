@@ -0,0 +1,341 @@
+package client
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func testEvent(i int, size int) GenericEvent {
+	body, _ := json.Marshal(strings.Repeat("x", size))
+	return GenericEvent{
+		Id:        fmt.Sprintf("event-%d", i),
+		Timestamp: time.Date(2022, 1, 1, 0, 0, i, 0, time.UTC),
+		Name:      "test",
+		Source:    "spool_test",
+		Body:      body,
+	}
+}
+
+func openTestSpool(t *testing.T, dir string) *spool {
+	t.Helper()
+
+	s, err := openSpool(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { s.close() })
+	return s
+}
+
+// take reads the events left in the spool, in order, taking them out of it.
+func take(t *testing.T, s *spool) []string {
+	t.Helper()
+
+	ids := []string{}
+	for {
+		event, size, ok, err := s.peek()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !ok {
+			return ids
+		}
+		ids = append(ids, event.Id)
+		if err := s.advance(size); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestSpoolReopen(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openTestSpool(t, dir)
+	for i := 0; i < 3; i++ {
+		if err := s.append(testEvent(i, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSpool(t, dir)
+	if stats := s.stats(); stats.Events != 3 {
+		t.Fatalf("expected 3 events after reopening, got %d", stats.Events)
+	}
+
+	// Events appended after reopening go after the ones already there.
+	if err := s.append(testEvent(3, 10)); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(take(t, s), ",")
+	if want := "event-0,event-1,event-2,event-3"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestSpoolTruncatedRecord(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openTestSpool(t, dir)
+	for i := 0; i < 2; i++ {
+		if err := s.append(testEvent(i, 10)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tear the last record as a crash halfway through appending it would.
+	path := filepath.Join(dir, segmentName(0))
+	info, err := os.Stat(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Truncate(path, info.Size()-5); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSpool(t, dir)
+	if stats := s.stats(); stats.Events != 1 {
+		t.Fatalf("expected the torn record to be cut off, got %d events", stats.Events)
+	}
+
+	if err := s.append(testEvent(2, 10)); err != nil {
+		t.Fatal(err)
+	}
+	got := strings.Join(take(t, s), ",")
+	if want := "event-0,event-2"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestSpoolSegmentBoundary(t *testing.T) {
+	dir := t.TempDir()
+
+	// Three events fit in a segment.
+	const size = maxSegmentSize * 2 / 7
+	s := openTestSpool(t, dir)
+	for i := 0; i < 5; i++ {
+		if err := s.append(testEvent(i, size)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if stats := s.stats(); stats.Segments != 2 {
+		t.Fatalf("expected 2 segments, got %d", stats.Segments)
+	}
+
+	for i := 0; i < 4; i++ {
+		event, n, ok, err := s.peek()
+		if err != nil || !ok {
+			t.Fatalf("expected an event, got %v", err)
+		}
+		if want := fmt.Sprintf("event-%d", i); event.Id != want {
+			t.Fatalf("expected %s, got %s", want, event.Id)
+		}
+		if err := s.advance(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The cursor has moved into the second segment and the first is gone.
+	if _, err := os.Stat(filepath.Join(dir, segmentName(0))); !os.IsNotExist(err) {
+		t.Errorf("expected the first segment to be deleted, got %v", err)
+	}
+	if stats := s.stats(); stats.Segments != 1 || stats.Events != 1 {
+		t.Errorf("expected 1 event in 1 segment, got %+v", stats)
+	}
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openTestSpool(t, dir)
+	if s.cursor.Segment != 1 {
+		t.Errorf("expected the cursor in segment 1, got %d", s.cursor.Segment)
+	}
+	got := strings.Join(take(t, s), ",")
+	if want := "event-4"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestSpoolStatsAfterRestart(t *testing.T) {
+	dir := t.TempDir()
+
+	s := openTestSpool(t, dir)
+	for i := 0; i < 3; i++ {
+		if err := s.append(testEvent(i, 100)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	_, n, _, err := s.peek()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.advance(n); err != nil {
+		t.Fatal(err)
+	}
+	before := s.stats()
+	if err := s.close(); err != nil {
+		t.Fatal(err)
+	}
+
+	after := openTestSpool(t, dir).stats()
+	if after.Events != 2 || after.Bytes != before.Bytes || after.Segments != 1 {
+		t.Errorf("expected %+v after restarting, got %+v", before, after)
+	}
+}
+
+// testServer accepts events once up is set, failing with 503 until then, and
+// records the IDs of the events it accepted. Events with an ID in status are
+// answered with that status instead.
+type testServer struct {
+	mu       sync.Mutex
+	up       bool
+	status   map[string]int
+	received []string
+	rejected int
+}
+
+func (ts *testServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	event := GenericEvent{}
+	if err := json.NewDecoder(r.Body).Decode(&event); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		return
+	}
+
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	if !ts.up {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if status, ok := ts.status[event.Id]; ok {
+		ts.rejected++
+		w.WriteHeader(status)
+		w.Write([]byte(`{"errors": ["rejected"]}`))
+		return
+	}
+	ts.received = append(ts.received, event.Id)
+	json.NewEncoder(w).Encode(event)
+}
+
+func (ts *testServer) set(up bool, status map[string]int) {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	ts.up = up
+	ts.status = status
+}
+
+func (ts *testServer) rejections() int {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return ts.rejected
+}
+
+func (ts *testServer) ids() string {
+	ts.mu.Lock()
+	defer ts.mu.Unlock()
+
+	return strings.Join(ts.received, ",")
+}
+
+func newSpoolingClient(t *testing.T, ts *testServer) *Client {
+	t.Helper()
+
+	server := httptest.NewServer(ts)
+	t.Cleanup(server.Close)
+
+	c, err := NewClient(server.URL, nil, WithSpool(t.TempDir()), WithRetryPolicy(RetryPolicy{MaxAttempts: 1}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	return c
+}
+
+// waitForDrain waits for the spool to empty out.
+func waitForDrain(t *testing.T, c *Client) SpoolStats {
+	t.Helper()
+
+	deadline := time.Now().Add(10 * time.Second)
+	for {
+		stats := c.SpoolStats()
+		if stats.Events == 0 {
+			return stats
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("spool not drained: %+v", stats)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestDrainInOrder(t *testing.T) {
+	ts := &testServer{}
+	c := newSpoolingClient(t, ts)
+
+	for i := 0; i < 5; i++ {
+		if _, err := c.Publish(testEvent(i, 10)); err != nil {
+			t.Fatalf("expected the event to be spooled, got %v", err)
+		}
+	}
+	if stats := c.SpoolStats(); stats.Events != 5 {
+		t.Fatalf("expected 5 spooled events, got %d", stats.Events)
+	}
+
+	ts.set(true, nil)
+	stats := waitForDrain(t, c)
+	if stats.Dropped != 0 {
+		t.Errorf("expected no events dropped, got %d", stats.Dropped)
+	}
+	if got, want := ts.ids(), "event-0,event-1,event-2,event-3,event-4"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
+
+func TestDrainDropsOnlyRejectedEvents(t *testing.T) {
+	ts := &testServer{}
+	c := newSpoolingClient(t, ts)
+
+	for i := 0; i < 3; i++ {
+		if _, err := c.Publish(testEvent(i, 10)); err != nil {
+			t.Fatalf("expected the event to be spooled, got %v", err)
+		}
+	}
+
+	// A revoked key holds the event back rather than dropping it.
+	ts.set(true, map[string]int{"event-0": http.StatusUnauthorized})
+	deadline := time.Now().Add(10 * time.Second)
+	for ts.rejections() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected the event to be sent")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := c.SpoolStats(); stats.Events != 3 || stats.Dropped != 0 {
+		t.Fatalf("expected all 3 events kept, got %+v", stats)
+	}
+
+	ts.set(true, map[string]int{"event-1": http.StatusUnprocessableEntity})
+	stats := waitForDrain(t, c)
+	if stats.Dropped != 1 {
+		t.Errorf("expected 1 event dropped, got %d", stats.Dropped)
+	}
+	if got, want := ts.ids(), "event-0,event-2"; got != want {
+		t.Errorf("expected %s, got %s", want, got)
+	}
+}
@@ -0,0 +1,30 @@
+package client
+
+// Prefix of the names of tombstone events. The tombstone for an event named
+// login is named tombstone.login, so consumers can bind to the tombstones of
+// just the events they keep copies of.
+const TombstonePrefix = "tombstone."
+
+// Actions recorded in tombstones.
+const (
+	TombstoneDeleted  = "deleted"
+	TombstoneRedacted = "redacted"
+)
+
+// Tombstone is the body of the event published when a stored event is
+// deleted or has its personal data redacted, so that downstream copies can be
+// purged too.
+type Tombstone struct {
+	EventId   string `json:"eventId"`
+	EventName string `json:"eventName"`
+	Action    string `json:"action"`
+	ErasureId string `json:"erasureId"`
+}
+
+// PersonalFields lists the body fields of each event published by this
+// package that hold personal data, which are redacted on erasure.
+var PersonalFields = map[string][]string{
+	"login":             {"firstName", "lastName", "email"},
+	"user.invite":       {"invitedBy", "email"},
+	"user.solicitation": {"id", "name", "givenName", "familyName", "email"},
+}
@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -17,6 +18,10 @@ type Tweet struct {
 }
 
 func (c *Client) EmitTweetEvent(source string, body Tweet) (GenericEvent, error) {
+	return c.EmitTweetEventContext(context.Background(), source, body)
+}
+
+func (c *Client) EmitTweetEventContext(ctx context.Context, source string, body Tweet) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(body)
 	if err != nil {
@@ -32,5 +37,5 @@ func (c *Client) EmitTweetEvent(source string, body Tweet) (GenericEvent, error)
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
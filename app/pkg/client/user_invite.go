@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -13,6 +14,10 @@ type Invite struct {
 }
 
 func (c *Client) EmitInviteEvent(source string, payload Invite) (GenericEvent, error) {
+	return c.EmitInviteEventContext(context.Background(), source, payload)
+}
+
+func (c *Client) EmitInviteEventContext(ctx context.Context, source string, payload Invite) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -28,5 +33,5 @@ func (c *Client) EmitInviteEvent(source string, payload Invite) (GenericEvent, e
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
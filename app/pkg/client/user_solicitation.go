@@ -1,6 +1,7 @@
 package client
 
 import (
+	"context"
 	"encoding/json"
 	"time"
 
@@ -16,6 +17,10 @@ type Solicitation struct {
 }
 
 func (c *Client) EmitUserSolicitationEvent(source string, payload Solicitation) (GenericEvent, error) {
+	return c.EmitUserSolicitationEventContext(context.Background(), source, payload)
+}
+
+func (c *Client) EmitUserSolicitationEventContext(ctx context.Context, source string, payload Solicitation) (GenericEvent, error) {
 	// Serialize the body to a JSON string.
 	data, err := json.Marshal(payload)
 	if err != nil {
@@ -31,5 +36,5 @@ func (c *Client) EmitUserSolicitationEvent(source string, payload Solicitation)
 		Body:      data,
 	}
 
-	return c.Publish(e)
+	return c.PublishContext(ctx, e)
 }
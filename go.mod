@@ -3,17 +3,20 @@ module github.com/allokate-ai/events
 go 1.18
 
 require (
+	github.com/allokate-ai/environment v0.0.0-20220811173816-5755ba0f94be
+	github.com/gin-contrib/sse v0.1.0
 	github.com/gin-gonic/contrib v0.0.0-20201101042839-6a891bf89f19
 	github.com/gin-gonic/gin v1.7.7
+	github.com/golang-jwt/jwt/v4 v4.5.2
 	github.com/google/uuid v1.3.0
+	github.com/gorilla/websocket v1.5.0
 	github.com/joho/godotenv v1.4.0
 	github.com/lib/pq v1.10.4
+	github.com/santhosh-tekuri/jsonschema/v5 v5.3.1
 	github.com/streadway/amqp v1.0.0
 )
 
 require (
-	github.com/allokate-ai/environment v0.0.0-20220811173816-5755ba0f94be // indirect
-	github.com/gin-contrib/sse v0.1.0 // indirect
 	github.com/go-playground/locales v0.14.0 // indirect
 	github.com/go-playground/universal-translator v0.18.0 // indirect
 	github.com/go-playground/validator/v10 v10.9.0 // indirect
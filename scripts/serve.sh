@@ -1,3 +1,3 @@
 #!/bin/bash
 
-go run app/cmd/server/main.go || exec "$0"
+go run ./app/cmd/server || exec "$0"